@@ -4,6 +4,9 @@ import (
 	"context"
 	"fmt"
 	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
 
 	"github.com/spf13/cobra"
 	"gitlab.com/WhyNotHugo/darkman"
@@ -12,6 +15,15 @@ import (
 
 var Version = "0.0.0-dev"
 
+// Returns the version of the running service, for --version.
+func daemonVersion() string {
+	version, err := libdarkman.GetVersion()
+	if err != nil {
+		return "unknown (not running?)"
+	}
+	return version
+}
+
 // rootCmd represents the base command when called without any subcommands
 var rootCmd = &cobra.Command{
 	Use:     "darkman",
@@ -25,7 +37,7 @@ var setCmd = &cobra.Command{
 	Args:      cobra.MatchAll(cobra.ExactArgs(1), cobra.OnlyValidArgs),
 	ValidArgs: []string{"dark", "light"},
 	RunE: func(cmd *cobra.Command, args []string) error {
-		return libdarkman.SetMode(args[0])
+		return libdarkman.SetModeWithReason(args[0], "darkman set")
 	},
 }
 
@@ -53,15 +65,49 @@ var toggleCmd = &cobra.Command{
 	},
 }
 
+func newReapplyCmd() *cobra.Command {
+	var script string
+	cmd := &cobra.Command{
+		Use:   "reapply",
+		Short: "Run the transition scripts for the current mode again",
+		Long: `Runs all transition scripts for the current mode again, even if they have
+already applied it. This is useful when an application starts and needs the
+current mode to be applied to it.
+
+With --script, only the script (or hook) with that name runs.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cmd.SilenceUsage = true
+			return libdarkman.Reapply(script)
+		},
+	}
+	cmd.Flags().StringVar(&script, "script", "", "Name of a single script to run")
+	return cmd
+}
+
+var reloadCmd = &cobra.Command{
+	Use:   "reload",
+	Short: "Make the running service read its configuration again",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cmd.SilenceUsage = true
+		return libdarkman.Reload()
+	},
+}
+
 func newRunCmd() *cobra.Command {
 	var readyFdRaw uint
+	var replace bool
 	cmd := &cobra.Command{
 		Use:   "run",
 		Short: "Run the darkman service",
 		Long: `This command starts the darkman service itself. It should only
 be used by a service manager, by a  session init script or alike.
 
-The service will run in foreground.`,
+The service will run in foreground.
+
+With --replace, an instance which is already running hands over its D-Bus
+names and exits. This allows upgrading without a gap in service.`,
 		RunE: func(cmd *cobra.Command, args []string) error {
 			var readyFd *os.File
 			if cmd.Flags().Changed("ready-fd") {
@@ -75,10 +121,11 @@ The service will run in foreground.`,
 			cmd.SilenceUsage = true
 
 			ctx := context.Background()
-			return darkman.ExecuteService(ctx, readyFd)
+			return darkman.ExecuteService(ctx, readyFd, Version, replace)
 		},
 	}
 	cmd.Flags().UintVar(&readyFdRaw, "ready-fd", 0, "File descriptor for readiness notification")
+	cmd.Flags().BoolVar(&replace, "replace", false, "Replace an instance which is already running")
 	return cmd
 }
 
@@ -86,21 +133,202 @@ var checkCmd = &cobra.Command{
 	Use:   "check",
 	Short: "Check the configuration file",
 	RunE: func(cmd *cobra.Command, args []string) error {
+		cmd.SilenceUsage = true
 		config := darkman.Default()
 		if err := darkman.ReadConfig(&config); err != nil {
 			return err
 		}
+		if err := config.Validate(); err != nil {
+			return err
+		}
 		fmt.Println("The configuration file is valid")
 		return nil
 	},
 }
 
+// Reads the configuration file, falling back to defaults if it cannot be read.
+func readConfigOrDefault() darkman.Config {
+	config := darkman.Default()
+	if err := darkman.ReadConfig(&config); err != nil {
+		fmt.Fprintln(os.Stderr, "Could not read configuration file:", err)
+	}
+	return config
+}
+
+func newVarCmd() *cobra.Command {
+	var mode string
+	cmd := &cobra.Command{
+		Use:   "var [NAME]",
+		Short: "Print a variable for the current mode",
+		Long: `Prints the value of a variable defined for the current mode in the
+configuration file. Without a name, prints all variables for the current mode.
+
+The current mode is queried from the running service, unless --mode is used.`,
+		Args: cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cmd.SilenceUsage = true
+			if mode == "" {
+				current, err := libdarkman.GetMode()
+				if err != nil {
+					return err
+				}
+				mode = current
+			} else if mode != "dark" && mode != "light" {
+				return fmt.Errorf("%s is not a valid mode", mode)
+			}
+
+			config := readConfigOrDefault()
+			vars := config.Vars[darkman.Mode(mode)]
+			if len(args) == 0 {
+				names := make([]string, 0, len(vars))
+				for name := range vars {
+					names = append(names, name)
+				}
+				sort.Strings(names)
+				for _, name := range names {
+					fmt.Printf("%v=%v\n", name, vars[name])
+				}
+				return nil
+			}
+
+			value, ok := vars[args[0]]
+			if !ok {
+				return fmt.Errorf("variable %v is not defined for %v mode", args[0], mode)
+			}
+			fmt.Println(value)
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&mode, "mode", "", "Print the value for this mode instead")
+	return cmd
+}
+
+var scriptsCmd = &cobra.Command{
+	Use:   "scripts",
+	Short: "Inspect and run transition scripts",
+}
+
+var scriptsListCmd = &cobra.Command{
+	Use:   "list [dark|light]",
+	Short: "List transition scripts and where each one is found",
+	Long: `Lists all transition scripts for each mode (or only the specified one).
+
+Scripts in directories with higher precedence shadow scripts with the same name
+in other directories. A file without an executable bit is never run, and also
+disables (masks) any scripts that it shadows.`,
+	Args:      cobra.MatchAll(cobra.MaximumNArgs(1), cobra.OnlyValidArgs),
+	ValidArgs: []string{"dark", "light"},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		modes := []darkman.Mode{darkman.DARK, darkman.LIGHT}
+		if len(args) == 1 {
+			modes = []darkman.Mode{darkman.Mode(args[0])}
+		}
+
+		config := readConfigOrDefault()
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		for _, mode := range modes {
+			fmt.Fprintf(w, "%v:\n", mode)
+			for _, script := range darkman.FindScripts(mode, config.ScriptDirs) {
+				status, shadowedStatus := "enabled", "shadowed"
+				if !script.Executable {
+					status, shadowedStatus = "disabled", "masked"
+				}
+				fmt.Fprintf(w, "  %v\t%v\t%v\n", status, script.Name, script.Path)
+				for _, shadowed := range script.Shadowed {
+					fmt.Fprintf(w, "  %v\t%v\t%v\n", shadowedStatus, shadowed.Name, shadowed.Path)
+				}
+			}
+			for _, hook := range darkman.HookScripts(config.Hooks[mode]) {
+				fmt.Fprintf(w, "  hook\t%v\t%v\n", hook.Name, strings.Join(hook.Command(), " "))
+			}
+		}
+		return w.Flush()
+	},
+}
+
+var linksCmd = &cobra.Command{
+	Use:   "links",
+	Short: "Inspect symlinks switched on each transition",
+}
+
+var linksStatusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "Show which mode each link currently points to",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		config := readConfigOrDefault()
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		for _, link := range config.Links {
+			mode, target, err := link.Status()
+			switch {
+			case os.IsNotExist(err):
+				fmt.Fprintf(w, "missing\t%v\t\n", link.Link)
+			case err != nil:
+				fmt.Fprintf(w, "error\t%v\t%v\n", link.Link, err)
+			case mode == darkman.NULL:
+				fmt.Fprintf(w, "other\t%v\t-> %v\n", link.Link, target)
+			default:
+				fmt.Fprintf(w, "%v\t%v\t-> %v\n", mode, link.Link, target)
+			}
+		}
+		return w.Flush()
+	},
+}
+
+func newScriptsRunCmd() *cobra.Command {
+	var dryRun bool
+	cmd := &cobra.Command{
+		Use:   "run <dark|light>",
+		Short: "Run the transition scripts for a mode",
+		Long: `Runs the transition scripts for the given mode in the foreground, exactly as
+the service would during a transition. The current mode is not changed. The
+outcome of each script is recorded separately from the service's, so that the
+service never skips a script because it was run manually.
+
+With --dry-run, only print which scripts would be executed.`,
+		Args:      cobra.MatchAll(cobra.ExactArgs(1), cobra.OnlyValidArgs),
+		ValidArgs: []string{"dark", "light"},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			config := readConfigOrDefault()
+
+			mode := darkman.Mode(args[0])
+			runner := darkman.NewScriptRunner(&config, darkman.LoadResults())
+			scripts := runner.Scripts(mode)
+			if !dryRun {
+				runner.RunForeground(mode, scripts)
+				return nil
+			}
+			for _, script := range scripts {
+				fmt.Println("Would run:", strings.Join(script.Command(), " "))
+			}
+			return nil
+		},
+	}
+	cmd.Flags().BoolVar(&dryRun, "dry-run", false, "Print the scripts that would run without running them")
+	return cmd
+}
+
 func init() {
+	// The service's version is only queried when --version is used.
+	cobra.AddTemplateFunc("daemonVersion", daemonVersion)
+	rootCmd.SetVersionTemplate("darkman version {{.Version}}\ndaemon version {{daemonVersion}}\n")
+	scriptsCmd.AddCommand(scriptsListCmd)
+	scriptsCmd.AddCommand(newScriptsRunCmd())
+
+	linksCmd.AddCommand(linksStatusCmd)
+
 	rootCmd.AddCommand(setCmd)
 	rootCmd.AddCommand(getCmd)
 	rootCmd.AddCommand(toggleCmd)
+	rootCmd.AddCommand(newReapplyCmd())
+	rootCmd.AddCommand(reloadCmd)
+	rootCmd.AddCommand(newVarCmd())
 	rootCmd.AddCommand(newRunCmd())
 	rootCmd.AddCommand(checkCmd)
+	rootCmd.AddCommand(scriptsCmd)
+	rootCmd.AddCommand(linksCmd)
 }
 
 func main() {
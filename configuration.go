@@ -1,16 +1,22 @@
 package darkman
 
 import (
+	"errors"
 	"fmt"
 	"log"
 	"os"
+	"os/exec"
 	"path/filepath"
+	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
 
 	"github.com/rxwycdh/rxhash"
 	"gitlab.com/WhyNotHugo/darkman/geoclue"
+	"gitlab.com/WhyNotHugo/darkman/kitty"
+	"gitlab.com/WhyNotHugo/darkman/swayipc"
 	"gopkg.in/yaml.v3"
 )
 
@@ -22,6 +28,133 @@ type Config struct {
 	UseGeoclue bool
 	DBusServer bool
 	Portal     bool
+	GnomeSync  bool
+	KdeSync    bool
+	// Bus name of another settings portal whose color-scheme is followed.
+	PortalSource string
+	Retry        RetryConfig
+	Hooks        map[Mode][]Hook
+	ScriptDirs   []string
+	Vars         map[Mode]map[string]string
+	Templates    []Template
+	Links        []Link
+	Neovim       NeovimConfig
+	Signals      []Signal
+	GSettings    []GSetting
+	Ini          []IniFile
+	Kitty        KittyConfig
+	Sway         SwayConfig
+}
+
+// Settings for running commands in sway (or i3) via its IPC socket.
+type SwayConfig struct {
+	// Path to the IPC socket. Defaults to $SWAYSOCK or $I3SOCK.
+	Socket string
+	// Commands to run for each mode.
+	Dark  []string
+	Light []string
+	// Time after which each command fails if it has not completed.
+	Timeout time.Duration
+}
+
+// Settings for applying colour themes to running kitty instances.
+type KittyConfig struct {
+	// Sockets on which kitty listens for remote control, as in kitty's
+	// listen_on setting. May contain glob patterns.
+	Sockets []string
+	// Theme files for each mode.
+	Dark  string
+	Light string
+	// Time after which an unresponsive instance is skipped.
+	Timeout time.Duration
+}
+
+// An INI-style configuration file with keys to set for each mode.
+type IniFile struct {
+	File string
+	// Notify KDE applications after changing this file.
+	Notify bool
+	// Keys to set for each mode, by section.
+	Dark  map[string]map[string]string
+	Light map[string]map[string]string
+}
+
+// A GSettings key written on each transition.
+type GSetting struct {
+	Schema string
+	// Only required for relocatable schemas.
+	Path string
+	Key  string
+	// Values for each mode, in the GVariant text format used by gsettings(1).
+	// The key is left untouched if empty.
+	Dark  string
+	Light string
+}
+
+// A signal sent to running processes on each transition.
+type Signal struct {
+	// Name of the processes, as shown by ps(1).
+	Name string
+	// Path to the executable of the processes. Used instead of the name.
+	Exe string
+	// Signal sent for each mode, e.g.: "SIGUSR1". No signal is sent if empty.
+	Dark  string
+	Light string
+}
+
+// Settings for updating running Neovim instances.
+type NeovimConfig struct {
+	Enabled bool
+	// Lua code to run instead of setting 'background'. The mode is passed as
+	// its only argument.
+	Lua string
+	// Time after which an unresponsive instance is skipped.
+	Timeout time.Duration
+}
+
+// A symlink which points to a different target for each mode.
+type Link struct {
+	// Path of the symlink itself.
+	Link string
+	// Targets for each mode. Relative targets are relative to the directory
+	// containing the symlink.
+	Dark  string
+	Light string
+}
+
+// A configuration file rendered from a template on each transition.
+type Template struct {
+	// Path to a text/template file.
+	Source string
+	// Path where the rendered output is written.
+	Output string
+}
+
+// A command to run on each transition, defined in the configuration file.
+type Hook struct {
+	// Name used in logs. Defaults to the basename of the executable.
+	Name string
+	// The executable and its arguments.
+	Command []string
+	// Time after which the command is killed. Zero means no timeout.
+	Timeout time.Duration
+	// Additional environment variables.
+	Env map[string]string
+	// Working directory. A leading "~/" is expanded to the user's home.
+	Dir string
+	// Retry for any failure, and not only when exiting with EX_TEMPFAIL.
+	Retry bool
+}
+
+// Settings for retrying scripts that fail transiently.
+type RetryConfig struct {
+	// Maximum amount of retries for each script.
+	Attempts int
+	// Delay before the first retry. It doubles with each retry.
+	Delay time.Duration
+	// Names of scripts which are retried for any failure, rather than only
+	// when they exit with EX_TEMPFAIL.
+	Scripts []string
 }
 
 type Time struct {
@@ -39,6 +172,19 @@ func Default() Config {
 		UseGeoclue: false,
 		DBusServer: true,
 		Portal:     true,
+		Retry: RetryConfig{
+			Attempts: 5,
+			Delay:    2 * time.Second,
+		},
+		Neovim: NeovimConfig{
+			Timeout: 2 * time.Second,
+		},
+		Kitty: KittyConfig{
+			Timeout: 2 * time.Second,
+		},
+		Sway: SwayConfig{
+			Timeout: 5 * time.Second,
+		},
 	}
 }
 
@@ -117,6 +263,18 @@ func (config *Config) LoadFromEnv() error {
 		config.Portal = *portal
 	}
 
+	if gnomesync, err := readBoolEnvVar("DARKMAN_GNOMESYNC"); err != nil {
+		return err
+	} else if gnomesync != nil {
+		config.GnomeSync = *gnomesync
+	}
+
+	if kdesync, err := readBoolEnvVar("DARKMAN_KDESYNC"); err != nil {
+		return err
+	} else if kdesync != nil {
+		config.KdeSync = *kdesync
+	}
+
 	return nil
 }
 
@@ -169,9 +327,11 @@ func openConfig() (*os.File, error) {
 		}
 	}
 
-	return nil, fmt.Errorf("no configuration file found anywhere")
+	return nil, errNoConfigFile
 }
 
+var errNoConfigFile = errors.New("no configuration file found anywhere")
+
 func ReadConfig(config *Config) error {
 	configFile, err := openConfig()
 	if err != nil {
@@ -194,6 +354,250 @@ func ReadConfig(config *Config) error {
 	return nil
 }
 
+// Reads the configuration file and environment variables on top of the
+// defaults, and validates the result.
+//
+// Unlike ReadConfig, which is lenient at start-up, any problem is returned as
+// an error. Not having a configuration file at all is fine.
+func LoadConfig() (Config, error) {
+	config := Default()
+	configFile, err := openConfig()
+	if err == nil {
+		defer configFile.Close()
+		if err := config.LoadFromYaml(configFile); err != nil {
+			return config, err
+		}
+	} else if err != errNoConfigFile {
+		return config, fmt.Errorf("error opening config file: %v", err)
+	}
+
+	if err := config.LoadFromEnv(); err != nil {
+		return config, fmt.Errorf("error reading environment variables: %v", err)
+	}
+	if err := config.Validate(); err != nil {
+		return config, err
+	}
+	return config, nil
+}
+
+// Variables are exported as environment variables, so must have valid names.
+var validVarName = regexp.MustCompile("^[A-Za-z_][A-Za-z0-9_]*$")
+
+// Expands a leading "~/" into the current user's home directory.
+func expandHome(path string) string {
+	if !strings.HasPrefix(path, "~/") {
+		return path
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return path
+	}
+	return filepath.Join(home, path[2:])
+}
+
+// Returns the name of a hook, or a default name if none was specified.
+func (hook *Hook) DisplayName() string {
+	if hook.Name != "" {
+		return hook.Name
+	}
+	if len(hook.Command) > 0 {
+		return filepath.Base(hook.Command[0])
+	}
+	return ""
+}
+
+// Check for settings which are syntactically valid, but cannot work.
+//
+// Returns an error describing all problems found.
+func (config *Config) Validate() error {
+	var problems []string
+
+	for _, dir := range config.ScriptDirs {
+		if info, err := os.Stat(expandHome(dir)); err != nil {
+			problems = append(problems, fmt.Sprintf("scriptdirs: %v", err))
+		} else if !info.IsDir() {
+			problems = append(problems, fmt.Sprintf("scriptdirs: %v is not a directory", dir))
+		}
+	}
+
+	for mode, vars := range config.Vars {
+		if mode != DARK && mode != LIGHT {
+			problems = append(problems, fmt.Sprintf("vars: %q is not a valid mode", mode))
+		}
+		for name := range vars {
+			if !validVarName.MatchString(name) {
+				problems = append(problems, fmt.Sprintf("vars.%v: %q is not a valid variable name", mode, name))
+			}
+		}
+	}
+
+	if config.PortalSource == PORTAL_BUS_NAME {
+		problems = append(problems, "portalsource: cannot follow darkman's own portal")
+	}
+
+	if config.Neovim.Enabled && config.Neovim.Timeout <= 0 {
+		problems = append(problems, "neovim: timeout must be positive")
+	}
+
+	if len(config.Kitty.Sockets) > 0 {
+		if config.Kitty.Timeout <= 0 {
+			problems = append(problems, "kitty: timeout must be positive")
+		}
+		for _, mode := range []Mode{DARK, LIGHT} {
+			path := config.Kitty.ForMode(mode)
+			if path == "" {
+				continue
+			}
+			if file, err := os.Open(path); err != nil {
+				problems = append(problems, fmt.Sprintf("kitty: invalid theme for %v mode: %v", mode, err))
+			} else {
+				if _, err := kitty.ParseTheme(file); err != nil {
+					problems = append(problems, fmt.Sprintf("kitty: invalid theme for %v mode: %v", mode, err))
+				}
+				file.Close()
+			}
+		}
+	}
+
+	if len(config.Sway.Dark) > 0 || len(config.Sway.Light) > 0 {
+		if config.Sway.Timeout <= 0 {
+			problems = append(problems, "sway: timeout must be positive")
+		}
+		if config.Sway.Socket == "" && swayipc.SocketPath() == "" {
+			problems = append(problems, "sway: no socket configured, and neither SWAYSOCK nor I3SOCK are set")
+		}
+	}
+
+	for i, signal := range config.Signals {
+		where := fmt.Sprintf("signals[%d]", i)
+		if signal.Name == "" && signal.Exe == "" {
+			problems = append(problems, fmt.Sprintf("%v: either name or exe is required", where))
+		} else if signal.Name != "" && signal.Exe != "" {
+			problems = append(problems, fmt.Sprintf("%v: only one of name or exe may be set", where))
+		}
+		if signal.Dark == "" && signal.Light == "" {
+			problems = append(problems, fmt.Sprintf("%v: no signal for either mode", where))
+		}
+		for _, mode := range []Mode{DARK, LIGHT} {
+			if name := signal.ForMode(mode); name != "" {
+				if _, err := ParseSignal(name); err != nil {
+					problems = append(problems, fmt.Sprintf("%v: %v", where, err))
+				}
+			}
+		}
+	}
+
+	for i, setting := range config.GSettings {
+		where := fmt.Sprintf("gsettings[%d]", i)
+		if setting.Schema == "" || setting.Key == "" {
+			problems = append(problems, fmt.Sprintf("%v: schema and key are required", where))
+		}
+		if setting.Path != "" && !strings.HasPrefix(setting.Path, "/") {
+			problems = append(problems, fmt.Sprintf("%v: path must be absolute", where))
+		}
+	}
+	for _, mode := range []Mode{DARK, LIGHT} {
+		if _, err := gsettingsChanges(config.GSettings, mode); err != nil {
+			problems = append(problems, fmt.Sprintf("gsettings: %v", err))
+		}
+	}
+
+	for i, file := range config.Ini {
+		where := fmt.Sprintf("ini[%d]", i)
+		if file.File == "" {
+			problems = append(problems, fmt.Sprintf("%v: file is empty", where))
+		} else if info, err := os.Stat(filepath.Dir(expandHome(file.File))); err != nil {
+			problems = append(problems, fmt.Sprintf("%v: invalid file: %v", where, err))
+		} else if !info.IsDir() {
+			problems = append(problems, fmt.Sprintf("%v: %v is not a directory", where, filepath.Dir(file.File)))
+		}
+		for _, mode := range []Mode{DARK, LIGHT} {
+			for section, keys := range file.ForMode(mode) {
+				for key, value := range keys {
+					if key == "" || strings.ContainsAny(key, "=\n") || strings.Contains(value, "\n") {
+						problems = append(problems, fmt.Sprintf("%v: invalid key %q in section %q", where, key, section))
+					}
+				}
+			}
+		}
+	}
+
+	for i, tmpl := range config.Templates {
+		where := fmt.Sprintf("templates[%d]", i)
+		if tmpl.Source == "" {
+			problems = append(problems, fmt.Sprintf("%v: source is empty", where))
+		} else if _, err := parseTemplate(tmpl.Source); err != nil {
+			problems = append(problems, fmt.Sprintf("%v: %v", where, err))
+		}
+		if tmpl.Output == "" {
+			problems = append(problems, fmt.Sprintf("%v: output is empty", where))
+		} else if info, err := os.Stat(filepath.Dir(expandHome(tmpl.Output))); err != nil {
+			problems = append(problems, fmt.Sprintf("%v: invalid output: %v", where, err))
+		} else if !info.IsDir() {
+			problems = append(problems, fmt.Sprintf("%v: %v is not a directory", where, filepath.Dir(tmpl.Output)))
+		}
+	}
+
+	for i, link := range config.Links {
+		where := fmt.Sprintf("links[%d]", i)
+		if link.Link == "" {
+			problems = append(problems, fmt.Sprintf("%v: link is empty", where))
+			continue
+		}
+		if info, err := os.Lstat(expandHome(link.Link)); err == nil && info.Mode()&os.ModeSymlink == 0 {
+			problems = append(problems, fmt.Sprintf("%v: %v exists and is not a symlink", where, link.Link))
+		}
+		for _, mode := range []Mode{DARK, LIGHT} {
+			target := link.Target(mode)
+			if target == "" {
+				problems = append(problems, fmt.Sprintf("%v: no target for %v mode", where, mode))
+			} else if _, err := os.Stat(link.resolve(target)); err != nil {
+				problems = append(problems, fmt.Sprintf("%v: invalid target for %v mode: %v", where, mode, err))
+			}
+		}
+	}
+
+	for mode, hooks := range config.Hooks {
+		if mode != DARK && mode != LIGHT {
+			problems = append(problems, fmt.Sprintf("hooks: %q is not a valid mode", mode))
+		}
+		names := make(map[string]bool)
+		for i, hook := range hooks {
+			where := fmt.Sprintf("hooks.%v[%d]", mode, i)
+			if len(hook.Command) == 0 {
+				problems = append(problems, fmt.Sprintf("%v: command is empty", where))
+				continue
+			}
+			if _, err := exec.LookPath(expandHome(hook.Command[0])); err != nil {
+				problems = append(problems, fmt.Sprintf("%v: %v", where, err))
+			}
+			if hook.Timeout < 0 {
+				problems = append(problems, fmt.Sprintf("%v: timeout must not be negative", where))
+			}
+			if hook.Dir != "" {
+				if info, err := os.Stat(expandHome(hook.Dir)); err != nil {
+					problems = append(problems, fmt.Sprintf("%v: invalid dir: %v", where, err))
+				} else if !info.IsDir() {
+					problems = append(problems, fmt.Sprintf("%v: %v is not a directory", where, hook.Dir))
+				}
+			}
+			if strings.Contains(hook.Name, "/") {
+				problems = append(problems, fmt.Sprintf("%v: name must not contain slashes", where))
+			}
+			if names[hook.DisplayName()] {
+				problems = append(problems, fmt.Sprintf("%v: duplicate name %q", where, hook.DisplayName()))
+			}
+			names[hook.DisplayName()] = true
+		}
+	}
+
+	if len(problems) > 0 {
+		sort.Strings(problems)
+		return fmt.Errorf("invalid configuration:\n  %v", strings.Join(problems, "\n  "))
+	}
+	return nil
+}
+
 func (config *Config) GetLocation() (*geoclue.Location, *Time, error) {
 	if ((config.Lat == nil && config.Lng != nil) ||
 		(config.Lat != nil && config.Lng == nil)) &&
@@ -227,19 +631,53 @@ func (config *Config) GetLocation() (*geoclue.Location, *Time, error) {
 			Sunset:  sunset,
 		}
 		return nil, &location, nil
-	} else {
+	} else if config.Lat != nil && config.Lng != nil {
 		location := geoclue.Location{
 			Lat: *config.Lat,
 			Lng: *config.Lng,
 		}
 		return &location, nil, nil
 	}
+
+	return nil, nil, fmt.Errorf("no location or time in the config")
 }
 
 func (config *Config) Hash() (string, error) {
 	return rxhash.HashStruct(config)
 }
 
+// Returns the names of the subsystems enabled by this configuration, so that
+// clients can tell which features are available. Transition scripts are always
+// enabled.
+func (config *Config) Capabilities() []string {
+	capabilities := []string{"scripts"}
+	enabled := []struct {
+		name    string
+		enabled bool
+	}{
+		{"hooks", len(config.Hooks) > 0},
+		{"templates", len(config.Templates) > 0},
+		{"links", len(config.Links) > 0},
+		{"neovim", config.Neovim.Enabled},
+		{"signals", len(config.Signals) > 0},
+		{"gsettings", len(config.GSettings) > 0},
+		{"ini", len(config.Ini) > 0},
+		{"kitty", len(config.Kitty.Sockets) > 0},
+		{"sway", len(config.Sway.Dark) > 0 || len(config.Sway.Light) > 0},
+		{"gnomesync", config.GnomeSync},
+		{"kdesync", config.KdeSync},
+		{"portal", config.Portal},
+		{"portalsource", config.PortalSource != ""},
+		{"geoclue", config.UseGeoclue},
+	}
+	for _, subsystem := range enabled {
+		if subsystem.enabled {
+			capabilities = append(capabilities, subsystem.name)
+		}
+	}
+	return capabilities
+}
+
 func (config *Config) String() string {
 	return fmt.Sprintf(
 		"{lat: %v, lng: %v, usegeoclue: %v, dbusserver: %v, portal: %v}",
@@ -2,7 +2,9 @@ package darkman
 
 import (
 	"os"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestLoadFromYaml(t *testing.T) {
@@ -99,3 +101,63 @@ func TestHashing(t *testing.T) {
 		t.Error("hash for different configs is the same")
 	}
 }
+
+func TestValidateHooks(t *testing.T) {
+	dir := t.TempDir()
+	testPath := dir + "/hooks.yaml"
+	yaml := `hooks:
+  dark:
+    - command: [sh, -c, "true"]
+      timeout: 5s
+      env:
+        FOO: bar
+    - name: missing
+      command: [/nonexistent/binary]
+  light:
+    - command: [sh]
+      dir: /nonexistent/
+`
+	if err := os.WriteFile(testPath, []byte(yaml), 0666); err != nil {
+		t.Fatal("failed to write test file:", err)
+	}
+
+	config := Default()
+	testfile, err := os.Open(testPath)
+	if err != nil {
+		t.Fatal("failed to open just-created configuration file:", err)
+	}
+	if err := config.LoadFromYaml(testfile); err != nil {
+		t.Fatal("failed to read configuration file:", err)
+	}
+
+	hook := config.Hooks[DARK][0]
+	if hook.DisplayName() != "sh" || hook.Timeout != 5*time.Second || hook.Env["FOO"] != "bar" {
+		t.Errorf("hook was not parsed correctly: %+v", hook)
+	}
+
+	err = config.Validate()
+	if err == nil {
+		t.Fatal("expected validation to fail")
+	}
+	if !strings.Contains(err.Error(), "hooks.dark[1]") || !strings.Contains(err.Error(), "hooks.light[0]") {
+		t.Errorf("missing expected problems in: %v", err)
+	}
+	if strings.Contains(err.Error(), "hooks.dark[0]") {
+		t.Errorf("valid hook reported as invalid: %v", err)
+	}
+}
+
+func TestCapabilities(t *testing.T) {
+	config := Default()
+	if got := strings.Join(config.Capabilities(), " "); got != "scripts portal" {
+		t.Errorf("want scripts and portal enabled by default, got %v", got)
+	}
+
+	config.Portal = false
+	config.UseGeoclue = true
+	config.Links = []Link{{Link: "theme.conf"}}
+	config.Sway.Light = []string{"output * bg light.png fill"}
+	if got := strings.Join(config.Capabilities(), " "); got != "scripts links sway geoclue" {
+		t.Errorf("unexpected capabilities: %v", got)
+	}
+}
@@ -4,34 +4,53 @@ import (
 	"context"
 	"fmt"
 	"log"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/godbus/dbus/v5"
 	"github.com/godbus/dbus/v5/introspect"
 	"github.com/godbus/dbus/v5/prop"
+	"gitlab.com/WhyNotHugo/darkman/geoclue"
 )
 
 type DBusHandle struct {
-	conn             *dbus.Conn
-	mode             string
-	prop             *prop.Properties
-	c                chan Mode
-	onChangeCallback func(Mode)
+	bus *busName
+	// The current connection, which changes if it is lost.
+	conn      *dbus.Conn
+	prop      *prop.Properties
+	mu        sync.Mutex
+	c         chan Mode
+	service   *Service
+	scheduler *Scheduler
+	info      DaemonInfo
+	onReapply func(string) error
+	onReload  func() error
 }
 
-func (handle *DBusHandle) emitChangeSignal() error {
-	return handle.conn.Emit("/nl/whynothugo/darkman", "nl.whynothugo.darkman.ModeChanged", handle.mode)
+// Describes the running daemon to D-Bus clients.
+type DaemonInfo struct {
+	Version string
+	// Names of the enabled subsystems; see Config.Capabilities.
+	Capabilities []string
+}
+
+func (handle *DBusHandle) emitChangeSignal(conn *dbus.Conn, mode Mode) error {
+	return conn.Emit("/nl/whynothugo/darkman", "nl.whynothugo.darkman.ModeChanged", string(mode))
 }
 
 // Changes the current mode to `Mode`. This function is to be called when the
 // mode is changed by another / subsystem.
 func (handle *DBusHandle) ChangeMode(newMode Mode) error {
-	if handle.conn == nil {
+	handle.mu.Lock()
+	conn, props := handle.conn, handle.prop
+	handle.mu.Unlock()
+	if conn == nil || !conn.Connected() {
 		return fmt.Errorf("cannot emit dbus signal; no connection to dbus")
 	}
 
-	handle.mode = string(newMode)
-	handle.prop.SetMust("nl.whynothugo.darkman", "Mode", handle.mode)
-	if err := handle.emitChangeSignal(); err != nil {
+	props.SetMust("nl.whynothugo.darkman", "Mode", string(newMode))
+	if err := handle.emitChangeSignal(conn, newMode); err != nil {
 		return fmt.Errorf("error emitting mode change dbus signal: %v", err)
 	}
 
@@ -46,63 +65,190 @@ func (handle *DBusHandle) handleChangeMode(c *prop.Change) *dbus.Error {
 		return prop.ErrInvalidArg
 	}
 
-	handle.mode = c.Value.(string)
-	handle.onChangeCallback(newMode)
+	// ModeChanged is emitted by ChangeMode, which listens to the service.
+	handle.service.SetMode(newMode, "Mode property")
+	return nil
+}
+
+// Called when a client sets the current mode. `reason` is only logged.
+func (handle *DBusHandle) SetMode(mode string, reason string) *dbus.Error {
+	if Mode(mode) != DARK && Mode(mode) != LIGHT {
+		return dbus.NewError("org.freedesktop.DBus.Error.InvalidArgs", []interface{}{fmt.Sprintf("%v is not a valid mode", mode)})
+	}
+	handle.service.SetMode(Mode(mode), reason)
+	return nil
+}
+
+// Called when a client toggles the current mode. Returns the new mode.
+func (handle *DBusHandle) Toggle() (string, *dbus.Error) {
+	return string(handle.service.Toggle()), nil
+}
+
+// Called when a client queries the service's state.
+func (handle *DBusHandle) GetState() (map[string]dbus.Variant, *dbus.Error) {
+	state := map[string]dbus.Variant{
+		"mode": dbus.MakeVariant(string(handle.service.CurrentMode())),
+	}
+	sunrise, sunset := handle.scheduler.SunTimes()
+	if !sunrise.IsZero() {
+		state["sunrise"] = dbus.MakeVariant(sunrise.Unix())
+	}
+	if !sunset.IsZero() {
+		state["sunset"] = dbus.MakeVariant(sunset.Unix())
+	}
+	return state, nil
+}
+
+// Returns the value of the Location property. It is empty if the location is
+// not known.
+func locationToDBus(location *LocationInfo) map[string]dbus.Variant {
+	if location == nil {
+		return map[string]dbus.Variant{}
+	}
+	return map[string]dbus.Variant{
+		"lat":       dbus.MakeVariant(location.Lat),
+		"lng":       dbus.MakeVariant(location.Lng),
+		"alt":       dbus.MakeVariant(location.Alt),
+		"source":    dbus.MakeVariant(location.Source),
+		"timestamp": dbus.MakeVariant(location.Timestamp.Unix()),
+	}
+}
+
+// Updates the Location property. This function is to be called each time the
+// scheduler obtains a location.
+func (handle *DBusHandle) ChangeLocation(location LocationInfo) {
+	handle.mu.Lock()
+	props := handle.prop
+	handle.mu.Unlock()
+	props.SetMust("nl.whynothugo.darkman", "Location", locationToDBus(&location))
+}
+
+// Announce a change to the enabled subsystems. Nothing is announced if they
+// have not changed.
+func (handle *DBusHandle) ChangeCapabilities(capabilities []string) {
+	handle.mu.Lock()
+	if strings.Join(capabilities, " ") == strings.Join(handle.info.Capabilities, " ") {
+		handle.mu.Unlock()
+		return
+	}
+	handle.info.Capabilities = capabilities
+	props := handle.prop
+	handle.mu.Unlock()
+	props.SetMust("nl.whynothugo.darkman", "Capabilities", capabilities)
+}
+
+// Closed when another instance takes over the D-Bus name.
+func (handle *DBusHandle) Replaced() <-chan struct{} {
+	return handle.bus.Replaced()
+}
+
+// Called when a client sets the current location.
+func (handle *DBusHandle) SetLocation(lat float64, lng float64) *dbus.Error {
+	// Written so that NaN is also rejected.
+	if !(lat >= -90 && lat <= 90 && lng >= -180 && lng <= 180) {
+		return dbus.NewError("org.freedesktop.DBus.Error.InvalidArgs", []interface{}{fmt.Sprintf("%v, %v is not a valid location", lat, lng)})
+	}
+
+	log.Printf("Location set via D-Bus: %v, %v.\n", lat, lng)
+	handle.scheduler.SetLocation(LocationInfo{
+		Location:  geoclue.Location{Lat: lat, Lng: lng},
+		Source:    LocationFromDBus,
+		Timestamp: time.Now(),
+	})
+	return nil
+}
+
+// Called when a client requests that the configuration be reloaded.
+func (handle *DBusHandle) Reload() *dbus.Error {
+	if err := handle.onReload(); err != nil {
+		return dbus.MakeFailedError(err)
+	}
+	return nil
+}
 
-	if err := handle.emitChangeSignal(); err != nil {
-		fmt.Println("Error emitting mode change dbus signal:", err)
+// Called when a client requests that the current mode be applied again.
+func (handle *DBusHandle) Reapply(script string) *dbus.Error {
+	if err := handle.onReapply(script); err != nil {
+		return dbus.MakeFailedError(err)
 	}
 	return nil
 }
 
 // Create a new D-Bus server instance for darkman's bespoke API.
 //
-// Takes as parameter a function that will be called each time the current
-// mode is changed via this D-Bus API.
+// Changes to the mode requested via this API are applied to `service`, and
+// locations are passed to `scheduler`. `onReapply` is called when a client
+// requests that scripts run again for the current mode, and `onReload` when a
+// client requests that the configuration be reloaded. `info` is exposed via the
+// Version and Capabilities properties. With `replace`, the name is taken over
+// from another instance.
 //
 // ChangeMode must be called on the returned handle each time that the current
-// mode changes by some other mechanism.
-func NewDbusServer(ctx context.Context, initial Mode, onChange func(Mode)) (*DBusHandle, error) {
+// mode changes by some other mechanism, and ChangeLocation each time that the
+// scheduler obtains a location.
+func NewDbusServer(ctx context.Context, service *Service, scheduler *Scheduler, info DaemonInfo, replace bool, onReapply func(string) error, onReload func() error) (*DBusHandle, error) {
 	handle := DBusHandle{
-		c:                make(chan Mode),
-		onChangeCallback: onChange,
-		mode:             string(initial),
+		c:         make(chan Mode),
+		service:   service,
+		scheduler: scheduler,
+		info:      info,
+		onReapply: onReapply,
+		onReload:  onReload,
 	}
 
-	if err := handle.start(ctx); err != nil {
+	if err := handle.start(ctx, replace); err != nil {
 		return nil, fmt.Errorf("could not start D-Bus server: %v", err)
 	}
 
 	return &handle, nil
 }
 
-func (handle *DBusHandle) start(ctx context.Context) (err error) {
-	opts := dbus.WithContext(ctx)
-	handle.conn, err = dbus.ConnectSessionBus(opts)
-	if err != nil {
-		return fmt.Errorf("could not connect to session D-Bus: %v", err)
-	}
+func (handle *DBusHandle) start(ctx context.Context, replace bool) error {
+	handle.bus = newBusName("nl.whynothugo.darkman", replace, handle.export)
+	return handle.bus.Start(ctx)
+}
 
-	// Define the "Mode" prop.
+// Export all objects on a new connection.
+func (handle *DBusHandle) export(conn *dbus.Conn) error {
+	handle.mu.Lock()
+	capabilities := handle.info.Capabilities
+	handle.mu.Unlock()
+
+	// Define the "Mode", "Location", "Version" and "Capabilities" props.
 	propsSpec := map[string]map[string]*prop.Prop{
 		"nl.whynothugo.darkman": {
 			"Mode": {
-				Value:    handle.mode,
+				Value:    string(handle.service.CurrentMode()),
 				Writable: true,
 				Emit:     prop.EmitTrue,
 				Callback: handle.handleChangeMode,
 			},
+			"Location": {
+				Value:    locationToDBus(handle.scheduler.Location()),
+				Writable: false,
+				Emit:     prop.EmitTrue,
+			},
+			"Version": {
+				Value:    handle.info.Version,
+				Writable: false,
+				Emit:     prop.EmitConst,
+			},
+			"Capabilities": {
+				Value:    capabilities,
+				Writable: false,
+				Emit:     prop.EmitTrue,
+			},
 		},
 	}
 
-	// Export the "Mode" prop.
-	handle.prop, err = prop.Export(handle.conn, "/nl/whynothugo/darkman", propsSpec)
+	// Export the props.
+	props, err := prop.Export(conn, "/nl/whynothugo/darkman", propsSpec)
 	if err != nil {
 		return fmt.Errorf("failed to export D-Bus prop: %v", err)
 	}
 
 	// Export the D-Bus object.
-	err = handle.conn.Export(handle, "/nl/whynothugo/darkman", "nl.whynothugo.darkman")
+	err = conn.Export(handle, "/nl/whynothugo/darkman", "nl.whynothugo.darkman")
 	if err != nil {
 		return fmt.Errorf("failed to export interface: %v", err)
 	}
@@ -118,10 +264,77 @@ func (handle *DBusHandle) start(ctx context.Context) (err error) {
 		},
 	}
 
+	// Declare our methods (for introspection only).
+	reapply := introspect.Method{
+		Name: "Reapply",
+		Args: []introspect.Arg{
+			{
+				Name:      "script",
+				Type:      "s",
+				Direction: "in",
+			},
+		},
+	}
+
+	setMode := introspect.Method{
+		Name: "SetMode",
+		Args: []introspect.Arg{
+			{
+				Name:      "mode",
+				Type:      "s",
+				Direction: "in",
+			},
+			{
+				Name:      "reason",
+				Type:      "s",
+				Direction: "in",
+			},
+		},
+	}
+	toggle := introspect.Method{
+		Name: "Toggle",
+		Args: []introspect.Arg{
+			{
+				Name:      "mode",
+				Type:      "s",
+				Direction: "out",
+			},
+		},
+	}
+	reload := introspect.Method{
+		Name: "Reload",
+	}
+	setLocation := introspect.Method{
+		Name: "SetLocation",
+		Args: []introspect.Arg{
+			{
+				Name:      "lat",
+				Type:      "d",
+				Direction: "in",
+			},
+			{
+				Name:      "lng",
+				Type:      "d",
+				Direction: "in",
+			},
+		},
+	}
+	getState := introspect.Method{
+		Name: "GetState",
+		Args: []introspect.Arg{
+			{
+				Name:      "state",
+				Type:      "a{sv}",
+				Direction: "out",
+			},
+		},
+	}
+
 	darkmanInterface := introspect.Interface{
 		Name:       "nl.whynothugo.darkman",
 		Signals:    []introspect.Signal{modeChanged},
-		Properties: handle.prop.Introspection("nl.whynothugo.darkman"),
+		Methods:    []introspect.Method{reapply, setMode, toggle, getState, setLocation, reload},
+		Properties: props.Introspection("nl.whynothugo.darkman"),
 	}
 
 	// Declare our whole interface (for introspection only).
@@ -135,7 +348,7 @@ func (handle *DBusHandle) start(ctx context.Context) (err error) {
 	}
 
 	// Export introspection data.
-	err = handle.conn.Export(
+	err = conn.Export(
 		introspect.NewIntrospectable(n),
 		"/nl/whynothugo/darkman",
 		"org.freedesktop.DBus.Introspectable",
@@ -144,16 +357,9 @@ func (handle *DBusHandle) start(ctx context.Context) (err error) {
 		return fmt.Errorf("failed to export dbus name: %v", err)
 	}
 
-	// Register our D-Bus name.
-	reply, err := handle.conn.RequestName("nl.whynothugo.darkman", dbus.NameFlagDoNotQueue)
-	if err != nil {
-		return fmt.Errorf("failed to register dbus name: %v", err)
-	}
-	if reply != dbus.RequestNameReplyPrimaryOwner {
-		return fmt.Errorf("can't register D-Bus name: name already taken")
-	}
-
-	log.Println("Listening on D-Bus `nl.whynothugo.darkman`...")
+	handle.mu.Lock()
+	handle.conn, handle.prop = conn, props
+	handle.mu.Unlock()
 
 	return nil
 }
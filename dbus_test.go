@@ -0,0 +1,232 @@
+package darkman
+
+import (
+	"context"
+	"errors"
+	"math"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/godbus/dbus/v5"
+	"gitlab.com/WhyNotHugo/darkman/libdarkman"
+)
+
+func TestDbusServer(t *testing.T) {
+	startSessionBus(t)
+	service := NewService(LIGHT)
+	modes := collectModes(service)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	scheduler := NewScheduler(nil, nil, service.ChangeMode)
+	reload := func() error { return errors.New("invalid configuration") }
+	info := DaemonInfo{Version: "1.2.3", Capabilities: []string{"scripts", "portal"}}
+	server, err := NewDbusServer(ctx, service, scheduler, info, false, func(string) error { return nil }, reload)
+	if err != nil {
+		t.Fatal("failed to start server:", err)
+	}
+	service.AddListener(server.ChangeMode)
+
+	if err := libdarkman.Reload(); err == nil || !strings.Contains(err.Error(), "invalid configuration") {
+		t.Errorf("want the reload error reported back, got %v", err)
+	}
+
+	if err := libdarkman.SetModeWithReason("dark", "test"); err != nil {
+		t.Fatal("failed to set mode:", err)
+	}
+	expectMode(t, modes, DARK)
+	if err := libdarkman.SetMode("dusk"); err == nil {
+		t.Error("want an error for an invalid mode")
+	}
+
+	mode, err := libdarkman.ToggleMode()
+	if err != nil || mode != "light" {
+		t.Errorf("want light mode after toggling, got %v (%v)", mode, err)
+	}
+	expectMode(t, modes, LIGHT)
+
+	got, err := libdarkman.GetState()
+	if err != nil {
+		t.Fatal("failed to get state:", err)
+	}
+	if got["mode"] != "light" {
+		t.Errorf("want light mode in state, got %v", got)
+	}
+	if _, ok := got["sunrise"]; ok {
+		t.Errorf("want no sunrise without a location, got %v", got)
+	}
+	if mode, err := libdarkman.GetMode(); err != nil || mode != "light" {
+		t.Errorf("want light mode, got %v (%v)", mode, err)
+	}
+
+	if version, err := libdarkman.GetVersion(); err != nil || version != "1.2.3" {
+		t.Errorf("want version 1.2.3, got %v (%v)", version, err)
+	}
+	server.ChangeCapabilities([]string{"scripts"})
+	capabilities, err := libdarkman.GetCapabilities()
+	if err != nil || strings.Join(capabilities, " ") != "scripts" {
+		t.Errorf("want updated capabilities, got %v (%v)", capabilities, err)
+	}
+}
+
+func TestDbusSetLocation(t *testing.T) {
+	address := startSessionBus(t)
+	service := NewService(LIGHT)
+	scheduler := NewScheduler(nil, nil, service.ChangeMode)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	server, err := NewDbusServer(ctx, service, scheduler, DaemonInfo{}, false, func(string) error { return nil }, func() error { return nil })
+	if err != nil {
+		t.Fatal("failed to start server:", err)
+	}
+	scheduler.AddLocationListener(server.ChangeLocation)
+	if err := scheduler.Start(ctx, false); err != nil {
+		t.Fatal("failed to start scheduler:", err)
+	}
+
+	conn, err := dbus.Connect(address)
+	if err != nil {
+		t.Fatal("failed to connect to bus:", err)
+	}
+	defer conn.Close()
+	obj := conn.Object("nl.whynothugo.darkman", "/nl/whynothugo/darkman")
+
+	if err := obj.Call("nl.whynothugo.darkman.SetLocation", 0, 95.0, 0.0).Err; err == nil {
+		t.Error("want an error for an invalid location")
+	}
+	if err := obj.Call("nl.whynothugo.darkman.SetLocation", 0, math.NaN(), 0.0).Err; err == nil {
+		t.Error("want an error for a NaN latitude")
+	}
+	if err := obj.Call("nl.whynothugo.darkman.SetLocation", 0, 0.0, math.Inf(1)).Err; err == nil {
+		t.Error("want an error for an infinite longitude")
+	}
+	if err := obj.Call("nl.whynothugo.darkman.SetLocation", 0, 52.37, 4.89).Err; err != nil {
+		t.Fatal("failed to set location:", err)
+	}
+
+	var location map[string]dbus.Variant
+	if err := obj.StoreProperty("nl.whynothugo.darkman.Location", &location); err != nil {
+		t.Fatal("failed to read location:", err)
+	}
+	if location["lat"].Value() != 52.37 || location["lng"].Value() != 4.89 || location["source"].Value() != LocationFromDBus {
+		t.Errorf("unexpected location: %v", location)
+	}
+
+	var state map[string]dbus.Variant
+	if err := obj.Call("nl.whynothugo.darkman.GetState", 0).Store(&state); err != nil {
+		t.Fatal("failed to get state:", err)
+	}
+	if _, ok := state["sunrise"]; !ok {
+		t.Errorf("want the next sunrise once the location is known, got %v", state)
+	}
+}
+
+func TestDbusServerReplace(t *testing.T) {
+	startSessionBus(t)
+	service := NewService(LIGHT)
+	scheduler := NewScheduler(nil, nil, service.ChangeMode)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	noop := func(string) error { return nil }
+	reload := func() error { return nil }
+
+	first, err := NewDbusServer(ctx, service, scheduler, DaemonInfo{Version: "1"}, false, noop, reload)
+	if err != nil {
+		t.Fatal("failed to start server:", err)
+	}
+	if _, err := NewDbusServer(ctx, service, scheduler, DaemonInfo{}, false, noop, reload); err == nil {
+		t.Fatal("want an error when the name is taken")
+	}
+
+	if _, err := NewDbusServer(ctx, service, scheduler, DaemonInfo{Version: "2"}, true, noop, reload); err != nil {
+		t.Fatal("failed to replace server:", err)
+	}
+	select {
+	case <-first.Replaced():
+	case <-time.After(5 * time.Second):
+		t.Fatal("want the first server to notice that it was replaced")
+	}
+	if version, err := libdarkman.GetVersion(); err != nil || version != "2" {
+		t.Errorf("want the new server to own the name, got %v (%v)", version, err)
+	}
+}
+
+func TestDbusServerReconnect(t *testing.T) {
+	startSessionBus(t)
+	service := NewService(DARK)
+	scheduler := NewScheduler(nil, nil, service.ChangeMode)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	server, err := NewDbusServer(ctx, service, scheduler, DaemonInfo{}, false, func(string) error { return nil }, func() error { return nil })
+	if err != nil {
+		t.Fatal("failed to start server:", err)
+	}
+
+	// Drop the connection, as if the bus had gone away.
+	server.mu.Lock()
+	server.conn.Close()
+	server.mu.Unlock()
+
+	var mode string
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(50 * time.Millisecond) {
+		if mode, err = libdarkman.GetMode(); err == nil {
+			break
+		}
+	}
+	if mode != "dark" {
+		t.Fatalf("want the server to reconnect with the current mode, got %v (%v)", mode, err)
+	}
+	if err := server.ChangeMode(LIGHT); err != nil {
+		t.Error("want mode changes to be emitted after reconnecting, got", err)
+	}
+}
+
+func TestDbusModePropertySignal(t *testing.T) {
+	address := startSessionBus(t)
+	service := NewService(LIGHT)
+	scheduler := NewScheduler(nil, nil, service.ChangeMode)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	server, err := NewDbusServer(ctx, service, scheduler, DaemonInfo{}, false, func(string) error { return nil }, func() error { return nil })
+	if err != nil {
+		t.Fatal("failed to start server:", err)
+	}
+	service.AddListener(server.ChangeMode)
+
+	conn, err := dbus.Connect(address)
+	if err != nil {
+		t.Fatal("failed to connect to bus:", err)
+	}
+	defer conn.Close()
+	if err := conn.AddMatchSignal(dbus.WithMatchMember("ModeChanged")); err != nil {
+		t.Fatal("failed to add match:", err)
+	}
+	signals := make(chan *dbus.Signal, 10)
+	conn.Signal(signals)
+
+	// Writing the same mode twice emits a single signal.
+	obj := conn.Object("nl.whynothugo.darkman", "/nl/whynothugo/darkman")
+	for i := 0; i < 2; i++ {
+		if err := obj.SetProperty("nl.whynothugo.darkman.Mode", dbus.MakeVariant("dark")); err != nil {
+			t.Fatal("failed to set mode:", err)
+		}
+	}
+
+	select {
+	case signal := <-signals:
+		if len(signal.Body) != 1 || signal.Body[0] != "dark" {
+			t.Errorf("unexpected signal: %v", signal)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("did not receive ModeChanged signal")
+	}
+	select {
+	case signal := <-signals:
+		t.Errorf("want a single ModeChanged signal, got another: %v", signal)
+	case <-time.After(200 * time.Millisecond):
+	}
+}
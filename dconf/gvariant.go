@@ -0,0 +1,241 @@
+package dconf
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// A single value, serialised in the GVariant format.
+//
+// Only basic types are supported, which covers nearly all GSettings keys.
+// See: https://developer.gnome.org/documentation/specifications/gvariant-specification-1.0.html
+type Value struct {
+	// The GVariant type string, e.g.: "s" or "b".
+	Type string
+	data []byte
+}
+
+// GVariant uses the host's byte order, which is little endian on all
+// platforms on which dconf is relevant.
+func le32(u uint32) []byte {
+	buf := make([]byte, 4)
+	binary.LittleEndian.PutUint32(buf, u)
+	return buf
+}
+
+func le64(u uint64) []byte {
+	buf := make([]byte, 8)
+	binary.LittleEndian.PutUint64(buf, u)
+	return buf
+}
+
+// Creates a string value.
+func String(s string) *Value {
+	return &Value{Type: "s", data: append([]byte(s), 0)}
+}
+
+// Creates a boolean value.
+func Bool(b bool) *Value {
+	if b {
+		return &Value{Type: "b", data: []byte{1}}
+	}
+	return &Value{Type: "b", data: []byte{0}}
+}
+
+// Creates a signed 32 bit integer value.
+func Int32(i int32) *Value {
+	return &Value{Type: "i", data: le32(uint32(i))}
+}
+
+// Creates an unsigned 32 bit integer value.
+func Uint32(u uint32) *Value {
+	return &Value{Type: "u", data: le32(u)}
+}
+
+// Creates a signed 64 bit integer value.
+func Int64(i int64) *Value {
+	return &Value{Type: "x", data: le64(uint64(i))}
+}
+
+// Creates an unsigned 64 bit integer value.
+func Uint64(u uint64) *Value {
+	return &Value{Type: "t", data: le64(u)}
+}
+
+// Creates a double precision floating point value.
+func Double(d float64) *Value {
+	return &Value{Type: "d", data: le64(math.Float64bits(d))}
+}
+
+// Parse a value written in (a subset of) the GVariant text format, as used
+// by gsettings(1).
+//
+// Supported are quoted strings, booleans, integers and doubles. Integers are
+// int32 unless prefixed with a type (e.g.: "uint32 5"). Any other text is
+// taken to be an unquoted string, like gsettings does for string keys.
+func ParseValue(text string) (*Value, error) {
+	text = strings.TrimSpace(text)
+
+	if fields := strings.Fields(text); len(fields) == 2 {
+		if value, err := parseTyped(fields[0], fields[1]); value != nil || err != nil {
+			return value, err
+		}
+	}
+
+	switch {
+	case text == "true":
+		return Bool(true), nil
+	case text == "false":
+		return Bool(false), nil
+	case len(text) >= 2 && (text[0] == '\'' || text[0] == '"'):
+		return parseQuoted(text)
+	}
+
+	if i, err := strconv.ParseInt(text, 10, 32); err == nil {
+		return Int32(int32(i)), nil
+	}
+	if d, err := strconv.ParseFloat(text, 64); err == nil && strings.ContainsAny(text, ".eE") {
+		return Double(d), nil
+	}
+	return String(text), nil
+}
+
+// Parse a number with an explicit type, e.g.: "uint32 5". Returns nil if the
+// type is not recognised.
+func parseTyped(kind string, number string) (*Value, error) {
+	var value *Value
+	var err error
+	switch kind {
+	case "int32":
+		var i int64
+		i, err = strconv.ParseInt(number, 0, 32)
+		value = Int32(int32(i))
+	case "uint32":
+		var u uint64
+		u, err = strconv.ParseUint(number, 0, 32)
+		value = Uint32(uint32(u))
+	case "int64":
+		var i int64
+		i, err = strconv.ParseInt(number, 0, 64)
+		value = Int64(i)
+	case "uint64":
+		var u uint64
+		u, err = strconv.ParseUint(number, 0, 64)
+		value = Uint64(u)
+	case "double":
+		var d float64
+		d, err = strconv.ParseFloat(number, 64)
+		value = Double(d)
+	default:
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid %v: %v", kind, err)
+	}
+	return value, nil
+}
+
+// Parse a string in single or double quotes, with backslash escapes.
+func parseQuoted(text string) (*Value, error) {
+	quote := text[0]
+	if text[len(text)-1] != quote {
+		return nil, fmt.Errorf("unterminated string: %v", text)
+	}
+
+	var s strings.Builder
+	body := text[1 : len(text)-1]
+	for i := 0; i < len(body); i++ {
+		c := body[i]
+		if c == quote {
+			return nil, fmt.Errorf("unescaped quote in string: %v", text)
+		}
+		if c == '\\' && i+1 < len(body) {
+			i++
+			c = body[i]
+		}
+		s.WriteByte(c)
+	}
+	return String(s.String()), nil
+}
+
+// Append zero bytes to `buf` until its length is a multiple of `alignment`.
+func pad(buf []byte, alignment int) []byte {
+	for len(buf)%alignment != 0 {
+		buf = append(buf, 0)
+	}
+	return buf
+}
+
+// Returns the size of framing offsets for a container with a body of `size`
+// bytes and `count` offsets.
+func offsetSize(size int, count int) int {
+	switch {
+	case size+count == 0:
+		return 0
+	case size+count <= 0xff:
+		return 1
+	case size+2*count <= 0xffff:
+		return 2
+	case size+4*count <= 0xffffffff:
+		return 4
+	default:
+		return 8
+	}
+}
+
+// Append framing offsets to a container's body.
+func appendOffsets(buf []byte, offsets []int) []byte {
+	size := offsetSize(len(buf), len(offsets))
+	for _, offset := range offsets {
+		for i := 0; i < size; i++ {
+			buf = append(buf, byte(offset>>(8*i)))
+		}
+	}
+	return buf
+}
+
+// Serialise a set of changes as a GVariant of type "a{smv}", as expected by
+// dconf. Keys map to their new values; a nil value resets a key.
+func serialiseChangeset(changes map[string]*Value) []byte {
+	keys := make([]string, 0, len(changes))
+	for key := range changes {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var buf []byte
+	var offsets []int
+	for _, key := range keys {
+		// Dictionary entries are aligned to their largest member, which is
+		// the variant (8 bytes).
+		buf = pad(buf, 8)
+		buf = append(buf, serialiseEntry(key, changes[key])...)
+		offsets = append(offsets, len(buf))
+	}
+
+	return appendOffsets(buf, offsets)
+}
+
+// Serialise a single "{smv}" dictionary entry.
+func serialiseEntry(key string, value *Value) []byte {
+	entry := append([]byte(key), 0)
+	keyEnd := len(entry)
+
+	entry = pad(entry, 8)
+	if value != nil {
+		// A variant is its child, a null byte, and the child's type. A
+		// "Just" maybe of a non-fixed-size type is followed by a null byte.
+		entry = append(entry, value.data...)
+		entry = append(entry, 0)
+		entry = append(entry, value.Type...)
+		entry = append(entry, 0)
+	}
+
+	// The key is not the last member, so its end is recorded in a framing
+	// offset.
+	return appendOffsets(entry, []int{keyEnd})
+}
@@ -0,0 +1,192 @@
+package dconf
+
+import (
+	"bufio"
+	"bytes"
+	"os/exec"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/godbus/dbus/v5"
+)
+
+func TestParseValue(t *testing.T) {
+	for text, want := range map[string]*Value{
+		"Adwaita-dark":   String("Adwaita-dark"),
+		"'prefer-dark'":  String("prefer-dark"),
+		`"it's"`:         String("it's"),
+		`'it\'s'`:        String("it's"),
+		"true":           Bool(true),
+		"false":          Bool(false),
+		"42":             Int32(42),
+		"-1":             Int32(-1),
+		"1.5":            Double(1.5),
+		"uint32 7":       Uint32(7),
+		"int64 -7":       Int64(-7),
+		"uint64 7":       Uint64(7),
+		"double 2":       Double(2),
+		"Adwaita dark":   String("Adwaita dark"),
+		"  spaced out  ": String("spaced out"),
+	} {
+		got, err := ParseValue(text)
+		if err != nil {
+			t.Errorf("%q: unexpected error: %v", text, err)
+			continue
+		}
+		if got.Type != want.Type || !bytes.Equal(got.data, want.data) {
+			t.Errorf("%q: want %v %v, got %v %v", text, want.Type, want.data, got.Type, got.data)
+		}
+	}
+
+	for _, text := range []string{"'unterminated", "'it's'", "uint32 -1", "int32 x"} {
+		if _, err := ParseValue(text); err == nil {
+			t.Errorf("%q: want an error", text)
+		}
+	}
+}
+
+func TestSerialiseChangeset(t *testing.T) {
+	for _, test := range []struct {
+		name    string
+		changes map[string]*Value
+		want    []byte
+	}{
+		{
+			name:    "empty",
+			changes: map[string]*Value{},
+			want:    nil,
+		},
+		{
+			name:    "string",
+			changes: map[string]*Value{"/a/b": String("x")},
+			want: []byte{
+				'/', 'a', '/', 'b', 0, 0, 0, 0, // key and padding
+				'x', 0, 0, 's', 0, // variant, and maybe's trailing null
+				5,  // end of key
+				14, // end of entry
+			},
+		},
+		{
+			name: "reset and bool",
+			changes: map[string]*Value{
+				"/k": nil,
+				"/b": Bool(true),
+			},
+			want: []byte{
+				'/', 'b', 0, 0, 0, 0, 0, 0, // key and padding
+				1, 0, 'b', 0, // variant, and maybe's trailing null
+				3,       // end of key
+				0, 0, 0, // padding
+				'/', 'k', 0, 0, 0, 0, 0, 0, // key and padding, no value
+				3,      // end of key
+				13, 25, // end of each entry
+			},
+		},
+		{
+			name:    "int32",
+			changes: map[string]*Value{"/i": Int32(-2)},
+			want: []byte{
+				'/', 'i', 0, 0, 0, 0, 0, 0, // key and padding
+				0xfe, 0xff, 0xff, 0xff, 0, 'i', 0, // variant, and maybe's trailing null
+				3,  // end of key
+				16, // end of entry
+			},
+		},
+	} {
+		got := serialiseChangeset(test.changes)
+		if !bytes.Equal(got, test.want) {
+			t.Errorf("%v: want %v, got %v", test.name, test.want, got)
+		}
+	}
+}
+
+func TestKeyPath(t *testing.T) {
+	if got := KeyPath("org.gnome.desktop.interface", "", "gtk-theme"); got != "/org/gnome/desktop/interface/gtk-theme" {
+		t.Errorf("unexpected path for a schema: %v", got)
+	}
+	if got := KeyPath("org.gnome.Terminal.Legacy.Profile", "/org/gnome/terminal/legacy/profiles:/:abc", "font"); got != "/org/gnome/terminal/legacy/profiles:/:abc/font" {
+		t.Errorf("unexpected path for a relocatable schema: %v", got)
+	}
+}
+
+// A stand-in for dconf's writer service, which records each changeset.
+type fakeWriter struct {
+	changes [][]byte
+	mu      sync.Mutex
+}
+
+func (writer *fakeWriter) Change(blob []byte) (string, *dbus.Error) {
+	writer.mu.Lock()
+	defer writer.mu.Unlock()
+	writer.changes = append(writer.changes, blob)
+	return "tag-1", nil
+}
+
+func (writer *fakeWriter) Changes() [][]byte {
+	writer.mu.Lock()
+	defer writer.mu.Unlock()
+	return append([][]byte(nil), writer.changes...)
+}
+
+// Start a private bus, and return its address.
+func startBus(t *testing.T) string {
+	daemon, err := exec.LookPath("dbus-daemon")
+	if err != nil {
+		t.Skip("dbus-daemon is not available:", err)
+	}
+	cmd := exec.Command(daemon, "--session", "--nofork", "--print-address=1")
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		t.Fatal("failed to create pipe:", err)
+	}
+	if err := cmd.Start(); err != nil {
+		t.Fatal("failed to start dbus-daemon:", err)
+	}
+	t.Cleanup(func() {
+		cmd.Process.Kill()
+		cmd.Wait()
+	})
+
+	address, err := bufio.NewReader(stdout).ReadString('\n')
+	if err != nil {
+		t.Fatal("failed to read bus address:", err)
+	}
+	return strings.TrimSpace(address)
+}
+
+func TestChange(t *testing.T) {
+	address := startBus(t)
+
+	service, err := dbus.Connect(address)
+	if err != nil {
+		t.Fatal("failed to connect to bus:", err)
+	}
+	defer service.Close()
+	writer := &fakeWriter{}
+	if err := service.Export(writer, WriterPath, WriterInterface); err != nil {
+		t.Fatal("failed to export writer:", err)
+	}
+	if _, err := service.RequestName(WriterName, dbus.NameFlagDoNotQueue); err != nil {
+		t.Fatal("failed to request name:", err)
+	}
+
+	client, err := dbus.Connect(address)
+	if err != nil {
+		t.Fatal("failed to connect to bus:", err)
+	}
+	defer client.Close()
+
+	changes := map[string]*Value{"/a/b": String("x")}
+	tag, err := Change(client, changes)
+	if err != nil {
+		t.Fatal("failed to write changes:", err)
+	}
+	if tag != "tag-1" {
+		t.Errorf("want tag-1, got %v", tag)
+	}
+	received := writer.Changes()
+	if len(received) != 1 || !bytes.Equal(received[0], serialiseChangeset(changes)) {
+		t.Errorf("writer received unexpected changes: %v", received)
+	}
+}
@@ -0,0 +1,186 @@
+package dconf
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+
+	"github.com/adrg/xdg"
+)
+
+// GVDB files start with "GVariant", as two little endian integers.
+const (
+	gvdbSignature0 = 0x72615647
+	gvdbSignature1 = 0x746e6169
+)
+
+const (
+	gvdbHeaderSize     = 24
+	gvdbHashHeaderSize = 8
+	gvdbHashItemSize   = 24
+	gvdbNoParent       = 0xffffffff
+)
+
+// Returns the path to the user's dconf database.
+func UserDatabasePath() string {
+	return filepath.Join(xdg.ConfigHome, "dconf", "user")
+}
+
+// A read-only view of a dconf database. The database is stored in the GVDB
+// format, which is a hash table of GVariant values.
+type Database struct {
+	values map[string][]byte
+}
+
+// Read a dconf database from a file.
+func ReadDatabase(path string) (*Database, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParseDatabase(data)
+}
+
+// Returns the data in a file referred to by a pointer, which consists of
+// start and end offsets.
+func gvdbDereference(data []byte, pointer []byte) ([]byte, error) {
+	start := binary.LittleEndian.Uint32(pointer[0:])
+	end := binary.LittleEndian.Uint32(pointer[4:])
+	if start > end || int(end) > len(data) {
+		return nil, fmt.Errorf("pointer out of bounds")
+	}
+	return data[start:end], nil
+}
+
+// Parse a dconf database in the GVDB format.
+//
+// Only little endian databases are supported. Lookups walk all items, rather
+// than using the hash table, which is fine for databases of this size.
+func ParseDatabase(data []byte) (*Database, error) {
+	if len(data) < gvdbHeaderSize ||
+		binary.LittleEndian.Uint32(data[0:]) != gvdbSignature0 ||
+		binary.LittleEndian.Uint32(data[4:]) != gvdbSignature1 {
+		return nil, fmt.Errorf("not a GVDB file")
+	}
+
+	table, err := gvdbDereference(data, data[16:24])
+	if err != nil {
+		return nil, fmt.Errorf("invalid root table: %v", err)
+	}
+	if len(table) < gvdbHashHeaderSize {
+		return nil, fmt.Errorf("root table is truncated")
+	}
+	// The upper bits hold the bloom filter's shift.
+	bloomWords := int(binary.LittleEndian.Uint32(table[0:]) & (1<<27 - 1))
+	buckets := int(binary.LittleEndian.Uint32(table[4:]))
+	itemsStart := gvdbHashHeaderSize + 4*bloomWords + 4*buckets
+	if itemsStart > len(table) {
+		return nil, fmt.Errorf("root table is truncated")
+	}
+	items := table[itemsStart:]
+	count := len(items) / gvdbHashItemSize
+
+	// Keys are stored relative to their parent, so resolve them first.
+	keys := make([]string, count)
+	resolving := make([]bool, count)
+	var resolve func(i int) (string, error)
+	resolve = func(i int) (string, error) {
+		if keys[i] != "" {
+			return keys[i], nil
+		}
+		if resolving[i] {
+			return "", fmt.Errorf("cycle in parents")
+		}
+		resolving[i] = true
+
+		item := items[i*gvdbHashItemSize:]
+		parent := binary.LittleEndian.Uint32(item[4:])
+		keyStart := int(binary.LittleEndian.Uint32(item[8:]))
+		keySize := int(binary.LittleEndian.Uint16(item[12:]))
+		if keyStart+keySize > len(data) {
+			return "", fmt.Errorf("key out of bounds")
+		}
+		key := string(data[keyStart : keyStart+keySize])
+
+		if parent != gvdbNoParent {
+			if int(parent) >= count {
+				return "", fmt.Errorf("parent out of bounds")
+			}
+			prefix, err := resolve(int(parent))
+			if err != nil {
+				return "", err
+			}
+			key = prefix + key
+		}
+		keys[i] = key
+		return key, nil
+	}
+
+	db := &Database{values: make(map[string][]byte)}
+	for i := 0; i < count; i++ {
+		item := items[i*gvdbHashItemSize:]
+		if item[14] != 'v' {
+			continue
+		}
+		key, err := resolve(i)
+		if err != nil {
+			return nil, fmt.Errorf("invalid item: %v", err)
+		}
+		value, err := gvdbDereference(data, item[16:24])
+		if err != nil {
+			return nil, fmt.Errorf("invalid value for %v: %v", key, err)
+		}
+		db.values[key] = value
+	}
+
+	return db, nil
+}
+
+// Returns the value of a key, or nil if it is not set.
+//
+// Values of types other than strings, booleans, integers and doubles are
+// returned as their GVariant type string.
+func (db *Database) Lookup(key string) (interface{}, error) {
+	data, ok := db.values[key]
+	if !ok {
+		return nil, nil
+	}
+	return parseVariant(data)
+}
+
+// Parse a serialised GVariant of type "v".
+func parseVariant(data []byte) (interface{}, error) {
+	// A variant is its child's data, a null byte, and the child's type.
+	sep := bytes.LastIndexByte(data, 0)
+	if sep < 0 {
+		return nil, fmt.Errorf("malformed variant")
+	}
+	child, kind := data[:sep], string(data[sep+1:])
+
+	size := map[string]int{"b": 1, "i": 4, "u": 4, "x": 8, "t": 8, "d": 8}[kind]
+	if size > 0 && len(child) != size {
+		return nil, fmt.Errorf("malformed value of type %v", kind)
+	}
+
+	switch kind {
+	case "s":
+		return string(bytes.TrimSuffix(child, []byte{0})), nil
+	case "b":
+		return child[0] != 0, nil
+	case "i":
+		return int64(int32(binary.LittleEndian.Uint32(child))), nil
+	case "u":
+		return int64(binary.LittleEndian.Uint32(child)), nil
+	case "x":
+		return int64(binary.LittleEndian.Uint64(child)), nil
+	case "t":
+		return binary.LittleEndian.Uint64(child), nil
+	case "d":
+		return math.Float64frombits(binary.LittleEndian.Uint64(child)), nil
+	default:
+		return kind, nil
+	}
+}
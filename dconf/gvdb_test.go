@@ -0,0 +1,120 @@
+package dconf
+
+import (
+	"encoding/binary"
+	"testing"
+
+	"github.com/godbus/dbus/v5"
+)
+
+type gvdbItem struct {
+	parent uint32
+	key    string
+	kind   byte
+	value  []byte
+}
+
+// Build a GVDB file with an empty bloom filter and no hash buckets, which is
+// enough for ParseDatabase.
+func buildGVDB(items []gvdbItem) []byte {
+	tableStart := gvdbHeaderSize
+	tableSize := gvdbHashHeaderSize + len(items)*gvdbHashItemSize
+	data := make([]byte, tableStart+tableSize)
+	binary.LittleEndian.PutUint32(data[0:], gvdbSignature0)
+	binary.LittleEndian.PutUint32(data[4:], gvdbSignature1)
+	binary.LittleEndian.PutUint32(data[16:], uint32(tableStart))
+	binary.LittleEndian.PutUint32(data[20:], uint32(tableStart+tableSize))
+
+	for i, item := range items {
+		offset := tableStart + gvdbHashHeaderSize + i*gvdbHashItemSize
+		binary.LittleEndian.PutUint32(data[offset+4:], item.parent)
+		binary.LittleEndian.PutUint32(data[offset+8:], uint32(len(data)))
+		binary.LittleEndian.PutUint16(data[offset+12:], uint16(len(item.key)))
+		data[offset+14] = item.kind
+		data = append(data, item.key...)
+
+		binary.LittleEndian.PutUint32(data[offset+16:], uint32(len(data)))
+		data = append(data, item.value...)
+		binary.LittleEndian.PutUint32(data[offset+20:], uint32(len(data)))
+	}
+	return data
+}
+
+// Serialise a value as a variant, as stored in dconf's database.
+func variant(value *Value) []byte {
+	return append(append(value.data, 0), value.Type...)
+}
+
+func TestParseDatabase(t *testing.T) {
+	data := buildGVDB([]gvdbItem{
+		{parent: gvdbNoParent, key: "/org/", kind: 'L'},
+		{parent: 0, key: "gnome/desktop/interface/color-scheme", kind: 'v', value: variant(String("prefer-dark"))},
+		{parent: 0, key: "example/enabled", kind: 'v', value: variant(Bool(true))},
+		{parent: gvdbNoParent, key: "/size", kind: 'v', value: variant(Int32(-12))},
+		{parent: gvdbNoParent, key: "/scale", kind: 'v', value: variant(Double(1.5))},
+	})
+
+	db, err := ParseDatabase(data)
+	if err != nil {
+		t.Fatal("failed to parse database:", err)
+	}
+
+	cases := map[string]interface{}{
+		"/org/gnome/desktop/interface/color-scheme": "prefer-dark",
+		"/org/example/enabled":                      true,
+		"/size":                                     int64(-12),
+		"/scale":                                    1.5,
+		"/org/":                                     nil,
+		"/missing":                                  nil,
+	}
+	for key, want := range cases {
+		got, err := db.Lookup(key)
+		if err != nil || got != want {
+			t.Errorf("%v: want %v, got %v (%v)", key, want, got, err)
+		}
+	}
+
+	if _, err := ParseDatabase([]byte("GVariant")); err == nil {
+		t.Error("want an error for a truncated file")
+	}
+	data[16] = 0xff
+	if _, err := ParseDatabase(data); err == nil {
+		t.Error("want an error for an invalid root pointer")
+	}
+}
+
+func TestNotification(t *testing.T) {
+	signal := &dbus.Signal{
+		Path: WriterPath,
+		Name: NotifySignal,
+		Body: []interface{}{"/org/gnome/desktop/", []string{"interface/color-scheme", "wm/"}, "tag-1"},
+	}
+	notification, err := ParseNotification(signal)
+	if err != nil {
+		t.Fatal("failed to parse notification:", err)
+	}
+	if notification.Tag != "tag-1" {
+		t.Errorf("want tag-1, got %v", notification.Tag)
+	}
+
+	affected := map[string]bool{
+		"/org/gnome/desktop/interface/color-scheme": true,
+		"/org/gnome/desktop/wm/theme":               true,
+		"/org/gnome/desktop/interface/gtk-theme":    false,
+	}
+	for key, want := range affected {
+		if got := notification.Affects(key); got != want {
+			t.Errorf("%v: want %v, got %v", key, want, got)
+		}
+	}
+
+	whole := Notification{Prefix: "/org/gnome/"}
+	if !whole.Affects("/org/gnome/desktop/interface/color-scheme") {
+		t.Error("want changes to a directory to affect keys under it")
+	}
+
+	signal.Name = "org.example.Other"
+	if _, err := ParseNotification(signal); err == nil {
+		t.Error("want an error for an unrelated signal")
+	}
+}
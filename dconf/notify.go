@@ -0,0 +1,63 @@
+package dconf
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/godbus/dbus/v5"
+)
+
+// Signal emitted by the writer service after each change.
+const NotifySignal = WriterInterface + ".Notify"
+
+// A change notification, as emitted by dconf's writer service.
+type Notification struct {
+	// A key, or a directory (ending in "/") under which all changes occurred.
+	Prefix string
+	// Keys or directories which changed, relative to Prefix. May be empty if
+	// Prefix itself changed.
+	Changes []string
+	// The tag returned when the change was written.
+	Tag string
+}
+
+// Returns true if this notification may indicate a change to `key`.
+func (notification *Notification) Affects(key string) bool {
+	if len(notification.Changes) == 0 {
+		return strings.HasPrefix(key, notification.Prefix)
+	}
+	for _, change := range notification.Changes {
+		if strings.HasPrefix(key, notification.Prefix+change) {
+			return true
+		}
+	}
+	return false
+}
+
+// Listen for change notifications on `conn`. Matching signals are delivered to
+// channels registered with conn.Signal, and can be parsed with
+// ParseNotification.
+func WatchNotifications(conn *dbus.Conn) error {
+	err := conn.AddMatchSignal(
+		dbus.WithMatchObjectPath(WriterPath),
+		dbus.WithMatchInterface(WriterInterface),
+		dbus.WithMatchMember("Notify"),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to listen for dconf notifications: %v", err)
+	}
+	return nil
+}
+
+// Returns the notification in `signal`, or an error if it is not a dconf
+// change notification.
+func ParseNotification(signal *dbus.Signal) (*Notification, error) {
+	if signal.Name != NotifySignal || signal.Path != WriterPath {
+		return nil, fmt.Errorf("not a dconf notification: %v", signal.Name)
+	}
+	notification := &Notification{}
+	if err := dbus.Store(signal.Body, &notification.Prefix, &notification.Changes, &notification.Tag); err != nil {
+		return nil, fmt.Errorf("malformed dconf notification: %v", err)
+	}
+	return notification, nil
+}
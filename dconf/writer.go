@@ -0,0 +1,50 @@
+// Package dconf implements a minimal client for dconf, the storage backend
+// for GSettings on most Linux systems.
+//
+// Changes are written via dconf's writer service over D-Bus, so neither the
+// dconf nor the gsettings binaries are required.
+package dconf
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/godbus/dbus/v5"
+)
+
+// Location of dconf's writer service for the user database.
+const (
+	WriterName      = "ca.desrt.dconf"
+	WriterPath      = "/ca/desrt/dconf/Writer/user"
+	WriterInterface = "ca.desrt.dconf.Writer"
+)
+
+// Returns the dconf path for a key in a GSettings schema.
+//
+// `path` is only required for relocatable schemas. Otherwise, the path is
+// derived from the schema's ID, which is the convention followed by nearly all
+// schemas (e.g.: "org.gnome.desktop.interface" is stored under
+// "/org/gnome/desktop/interface/").
+func KeyPath(schema string, path string, key string) string {
+	if path == "" {
+		path = "/" + strings.ReplaceAll(schema, ".", "/") + "/"
+	} else if !strings.HasSuffix(path, "/") {
+		path += "/"
+	}
+	return path + key
+}
+
+// Write a set of changes atomically. Keys are full dconf paths; a nil value
+// resets a key to its default.
+//
+// Returns the tag which identifies this change in dconf's change
+// notifications.
+func Change(conn *dbus.Conn, changes map[string]*Value) (string, error) {
+	var tag string
+	obj := conn.Object(WriterName, WriterPath)
+	err := obj.Call(WriterInterface+".Change", 0, serialiseChangeset(changes)).Store(&tag)
+	if err != nil {
+		return "", fmt.Errorf("failed to write to dconf: %v", err)
+	}
+	return tag, nil
+}
@@ -0,0 +1,161 @@
+package darkman
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+
+	"github.com/godbus/dbus/v5"
+	"gitlab.com/WhyNotHugo/darkman/dconf"
+)
+
+// GNOME's own dark style preference, as toggled via its quick settings.
+const colorSchemeKey = "/org/gnome/desktop/interface/color-scheme"
+
+// Returns the mode for a value of GNOME's color-scheme setting. Anything other
+// than "prefer-dark" (including the key being unset) means light mode.
+func colorSchemeMode(value interface{}) Mode {
+	if value == "prefer-dark" {
+		return DARK
+	}
+	return LIGHT
+}
+
+// Returns the value of GNOME's color-scheme setting for a mode.
+func colorSchemeValue(mode Mode) string {
+	if mode == DARK {
+		return "prefer-dark"
+	}
+	return "default"
+}
+
+// Keeps the current mode in sync with GNOME's color-scheme setting, in both
+// directions.
+//
+// Changes made by anyone else are applied as if they had been requested via
+// darkman's own D-Bus API. Changes written by darkman itself are recognised by
+// their tag, so they are not applied again.
+type GnomeSync struct {
+	database string
+	override func(Mode)
+	conn     *dbus.Conn
+	// The mode last written or seen in dconf.
+	mode Mode
+	// Tags of changes written by darkman for which no notification has been
+	// seen yet.
+	tags map[string]bool
+	mu   sync.Mutex
+}
+
+// Creates a new GnomeSync. Changes made by others are passed to `override`.
+func NewGnomeSync(override func(Mode)) *GnomeSync {
+	return &GnomeSync{
+		database: dconf.UserDatabasePath(),
+		override: override,
+		mode:     NULL,
+		tags:     make(map[string]bool),
+	}
+}
+
+// Read the current value of the color-scheme setting.
+func (gnome *GnomeSync) read() (Mode, error) {
+	db, err := dconf.ReadDatabase(gnome.database)
+	if os.IsNotExist(err) {
+		return LIGHT, nil
+	} else if err != nil {
+		return NULL, fmt.Errorf("failed to read dconf database: %v", err)
+	}
+	value, err := db.Lookup(colorSchemeKey)
+	if err != nil {
+		return NULL, fmt.Errorf("failed to read %v: %v", colorSchemeKey, err)
+	}
+	return colorSchemeMode(value), nil
+}
+
+// Connect to the session bus and start following changes to the setting,
+// until `ctx` is cancelled. If the connection is lost, it reconnects.
+func (gnome *GnomeSync) Start(ctx context.Context) error {
+	subscribe := func(conn *dbus.Conn) error {
+		if err := dconf.WatchNotifications(conn); err != nil {
+			return err
+		}
+		gnome.mu.Lock()
+		gnome.conn = conn
+		gnome.mu.Unlock()
+		return nil
+	}
+	return followSignals(ctx, "GNOME's color-scheme", subscribe, func(signal *dbus.Signal) {
+		notification, err := dconf.ParseNotification(signal)
+		if err != nil {
+			log.Println(err)
+			return
+		}
+		gnome.handle(notification)
+	})
+}
+
+// Apply a change to the setting, unless it was written by darkman itself.
+func (gnome *GnomeSync) handle(notification *dconf.Notification) {
+	if !notification.Affects(colorSchemeKey) {
+		return
+	}
+
+	gnome.mu.Lock()
+	if gnome.tags[notification.Tag] {
+		delete(gnome.tags, notification.Tag)
+		gnome.mu.Unlock()
+		return
+	}
+	gnome.mu.Unlock()
+
+	mode, err := gnome.read()
+	if err != nil {
+		log.Println("Could not follow GNOME's color-scheme:", err)
+		return
+	}
+
+	gnome.mu.Lock()
+	if mode == gnome.mode {
+		gnome.mu.Unlock()
+		return
+	}
+	gnome.mode = mode
+	gnome.mu.Unlock()
+
+	log.Printf("GNOME's color-scheme changed to %v mode.\n", mode)
+	gnome.override(mode)
+}
+
+// Write the setting for `mode`, unless it already has the right value.
+func (gnome *GnomeSync) ChangeMode(mode Mode) error {
+	if mode == NULL {
+		return nil
+	}
+
+	// Hold the lock until the tag is recorded, so that the notification for
+	// this change is not mistaken for someone else's.
+	gnome.mu.Lock()
+	defer gnome.mu.Unlock()
+
+	gnome.mode = mode
+	if gnome.conn == nil {
+		return fmt.Errorf("not connected to the session bus")
+	}
+	if current, err := gnome.read(); err == nil && current == mode {
+		return nil
+	}
+
+	value := colorSchemeValue(mode)
+	tag, err := dconf.Change(gnome.conn, map[string]*dconf.Value{
+		colorSchemeKey: dconf.String(value),
+	})
+	if err != nil {
+		return err
+	}
+	gnome.tags[tag] = true
+
+	log.Printf("Set GNOME's color-scheme to %v.\n", value)
+	return nil
+}
@@ -0,0 +1,119 @@
+package darkman
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/godbus/dbus/v5"
+	"gitlab.com/WhyNotHugo/darkman/dconf"
+)
+
+// A stand-in for dconf's writer service, which emits a notification for each
+// change, but does not store anything.
+type fakeDconf struct {
+	conn  *dbus.Conn
+	count int
+	mu    sync.Mutex
+}
+
+func (writer *fakeDconf) Change(blob []byte) (string, *dbus.Error) {
+	writer.mu.Lock()
+	writer.count++
+	tag := fmt.Sprintf("darkman-%d", writer.count)
+	writer.mu.Unlock()
+	writer.conn.Emit(dconf.WriterPath, dconf.NotifySignal, colorSchemeKey, []string{""}, tag)
+	return tag, nil
+}
+
+func (writer *fakeDconf) Count() int {
+	writer.mu.Lock()
+	defer writer.mu.Unlock()
+	return writer.count
+}
+
+func TestGnomeSync(t *testing.T) {
+	address := startSessionBus(t)
+	conn, err := dbus.Connect(address)
+	if err != nil {
+		t.Fatal("failed to connect to bus:", err)
+	}
+	defer conn.Close()
+	writer := &fakeDconf{conn: conn}
+	if err := conn.Export(writer, dconf.WriterPath, dconf.WriterInterface); err != nil {
+		t.Fatal("failed to export writer:", err)
+	}
+	if _, err := conn.RequestName(dconf.WriterName, dbus.NameFlagDoNotQueue); err != nil {
+		t.Fatal("failed to request name:", err)
+	}
+
+	overrides := make(chan Mode, 10)
+	gnome := NewGnomeSync(func(mode Mode) { overrides <- mode })
+	// The database does not exist, so the setting always reads as light.
+	gnome.database = filepath.Join(t.TempDir(), "user")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if err := gnome.Start(ctx); err != nil {
+		t.Fatal("failed to start:", err)
+	}
+
+	if err := gnome.ChangeMode(DARK); err != nil {
+		t.Fatal("failed to apply dark mode:", err)
+	}
+	if count := writer.Count(); count != 1 {
+		t.Errorf("want one change written, got %d", count)
+	}
+	if err := gnome.ChangeMode(LIGHT); err != nil {
+		t.Fatal("failed to apply light mode:", err)
+	}
+	if count := writer.Count(); count != 1 {
+		t.Errorf("want no change written when the setting matches, got %d", count)
+	}
+
+	// Notifications for other keys, or for darkman's own changes, are ignored.
+	gnome.ChangeMode(DARK)
+	conn.Emit(dconf.WriterPath, dconf.NotifySignal, "/org/gnome/desktop/interface/gtk-theme", []string{""}, "other-1")
+	conn.Emit(dconf.WriterPath, dconf.NotifySignal, "/org/gnome/desktop/", []string{"interface/"}, "other-2")
+
+	select {
+	case mode := <-overrides:
+		if mode != LIGHT {
+			t.Errorf("want light mode applied, got %v", mode)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for a change to be applied")
+	}
+	select {
+	case mode := <-overrides:
+		t.Errorf("want a single change applied, got %v", mode)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	// Changes are still followed after the connection is lost.
+	gnome.mu.Lock()
+	lost := gnome.conn
+	gnome.mu.Unlock()
+	lost.Close()
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(50 * time.Millisecond) {
+		gnome.mu.Lock()
+		reconnected := gnome.conn != lost
+		gnome.mu.Unlock()
+		if reconnected {
+			break
+		}
+	}
+	gnome.ChangeMode(DARK)
+	conn.Emit(dconf.WriterPath, dconf.NotifySignal, "/org/gnome/desktop/", []string{"interface/"}, "other-3")
+	select {
+	case mode := <-overrides:
+		if mode != LIGHT {
+			t.Errorf("want light mode applied after reconnecting, got %v", mode)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for a change to be applied after reconnecting")
+	}
+}
@@ -0,0 +1,85 @@
+package darkman
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"gitlab.com/WhyNotHugo/darkman/dconf"
+)
+
+// Returns the value for the given mode, in the GVariant text format.
+func (setting *GSetting) ForMode(mode Mode) string {
+	switch mode {
+	case DARK:
+		return setting.Dark
+	case LIGHT:
+		return setting.Light
+	default:
+		return ""
+	}
+}
+
+// Returns the dconf path where this setting is stored.
+func (setting *GSetting) KeyPath() string {
+	return dconf.KeyPath(setting.Schema, setting.Path, setting.Key)
+}
+
+// Returns all changes required to apply a mode. Settings without a value for
+// the given mode are not included.
+func gsettingsChanges(settings []GSetting, mode Mode) (map[string]*dconf.Value, error) {
+	changes := make(map[string]*dconf.Value)
+	for _, setting := range settings {
+		text := setting.ForMode(mode)
+		if text == "" {
+			continue
+		}
+		value, err := dconf.ParseValue(text)
+		if err != nil {
+			return nil, fmt.Errorf("invalid value for %v %v: %v", setting.Schema, setting.Key, err)
+		}
+		changes[setting.KeyPath()] = value
+	}
+	return changes, nil
+}
+
+// Writes GSettings keys on each transition via dconf's D-Bus API.
+type GSettingsWriter struct {
+	settings []GSetting
+	bus      *lazySessionBus
+}
+
+// Creates a new GSettingsWriter for the settings in `config`. It connects to
+// the session bus on first use, and disconnects when `ctx` is cancelled.
+func NewGSettingsWriter(ctx context.Context, config *Config) *GSettingsWriter {
+	return &GSettingsWriter{
+		settings: config.GSettings,
+		bus:      &lazySessionBus{ctx: ctx},
+	}
+}
+
+// Write all settings for `mode` in a single, atomic change.
+func (writer *GSettingsWriter) ChangeMode(mode Mode) error {
+	if mode == NULL || len(writer.settings) == 0 {
+		return nil
+	}
+
+	changes, err := gsettingsChanges(writer.settings, mode)
+	if err != nil {
+		return err
+	}
+	if len(changes) == 0 {
+		return nil
+	}
+
+	conn, err := writer.bus.Conn()
+	if err != nil {
+		return err
+	}
+	if _, err := dconf.Change(conn, changes); err != nil {
+		return err
+	}
+
+	log.Printf("Wrote %d GSettings key(s) for %v mode.\n", len(changes), mode)
+	return nil
+}
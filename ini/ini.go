@@ -0,0 +1,139 @@
+// Package ini edits INI-style configuration files, such as those used by GTK
+// (settings.ini) and KDE (kdeglobals, konsolerc).
+//
+// Files are edited in place: comments, blank lines, ordering and any keys
+// which are not modified are preserved exactly as they were.
+package ini
+
+import (
+	"bytes"
+	"strings"
+)
+
+// A single line of a file.
+type line struct {
+	text string
+	// Section to which this line belongs.
+	section string
+	// Key defined by this line, if any.
+	key string
+	// Offset in `text` at which the value starts.
+	valueStart int
+	// Whether this line is a section header.
+	header bool
+}
+
+// An INI file.
+type File struct {
+	lines []line
+	// Whether the last line ends with a newline.
+	trailingNewline bool
+}
+
+// Parse the contents of a file. Parsing never fails; lines which cannot be
+// parsed are preserved as they are.
+//
+// Section names are the text between the brackets of the section header.
+// KDE's nested groups are written as "Parent][Child".
+func Parse(data []byte) *File {
+	file := &File{trailingNewline: len(data) == 0 || bytes.HasSuffix(data, []byte("\n"))}
+	if len(data) == 0 {
+		return file
+	}
+
+	section := ""
+	for _, raw := range strings.Split(strings.TrimSuffix(string(data), "\n"), "\n") {
+		l := line{text: raw, section: section}
+		trimmed := strings.TrimSpace(raw)
+
+		switch {
+		case trimmed == "" || trimmed[0] == '#' || trimmed[0] == ';':
+		case trimmed[0] == '[' && trimmed[len(trimmed)-1] == ']':
+			section = trimmed[1 : len(trimmed)-1]
+			l.section = section
+			l.header = true
+		default:
+			if i := strings.IndexByte(raw, '='); i > 0 {
+				l.key = strings.TrimSpace(raw[:i])
+				l.valueStart = i + 1
+				for l.valueStart < len(raw) && raw[l.valueStart] == ' ' {
+					l.valueStart++
+				}
+			}
+		}
+		file.lines = append(file.lines, l)
+	}
+	return file
+}
+
+// Returns the value of a key, and whether it is defined. The last definition
+// of a key wins, like with most parsers.
+func (file *File) Get(section string, key string) (string, bool) {
+	value, found := "", false
+	for _, l := range file.lines {
+		if l.key != "" && l.section == section && l.key == key {
+			value, found = l.text[l.valueStart:], true
+		}
+	}
+	return value, found
+}
+
+// Set the value of a key.
+//
+// Existing definitions are updated in place. Otherwise, the key is added after
+// the last key in its section, creating the section at the end of the file if
+// necessary. Keys outside of any section use an empty section name.
+func (file *File) Set(section string, key string, value string) {
+	found := false
+	for i, l := range file.lines {
+		if l.key != "" && l.section == section && l.key == key {
+			file.lines[i].text = l.text[:l.valueStart] + value
+			found = true
+		}
+	}
+	if found {
+		return
+	}
+
+	newLine := line{
+		text:       key + "=" + value,
+		section:    section,
+		key:        key,
+		valueStart: len(key) + 1,
+	}
+
+	// Insert after the last key of the section (or its header). Comments
+	// after that usually refer to whatever follows them.
+	insertAt := -1
+	for i, l := range file.lines {
+		if l.section == section && (l.key != "" || l.header) {
+			insertAt = i + 1
+		}
+	}
+
+	if insertAt < 0 && section == "" {
+		insertAt = 0
+	} else if insertAt < 0 {
+		if len(file.lines) > 0 && strings.TrimSpace(file.lines[len(file.lines)-1].text) != "" {
+			file.lines = append(file.lines, line{section: file.lines[len(file.lines)-1].section})
+		}
+		file.lines = append(file.lines, line{text: "[" + section + "]", section: section, header: true})
+		insertAt = len(file.lines)
+	}
+
+	file.lines = append(file.lines, line{})
+	copy(file.lines[insertAt+1:], file.lines[insertAt:])
+	file.lines[insertAt] = newLine
+}
+
+// Returns the contents of the file.
+func (file *File) Bytes() []byte {
+	var buf bytes.Buffer
+	for i, l := range file.lines {
+		buf.WriteString(l.text)
+		if i < len(file.lines)-1 || file.trailingNewline {
+			buf.WriteByte('\n')
+		}
+	}
+	return buf.Bytes()
+}
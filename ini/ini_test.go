@@ -0,0 +1,74 @@
+package ini
+
+import "testing"
+
+const kdeglobals = `# Managed by hand
+top=level
+
+[General]
+ColorScheme=BreezeLight
+; Fonts are set elsewhere
+font = Noto Sans,10
+
+# Window colours
+[Colors:Window]
+BackgroundNormal=239,240,241
+`
+
+func TestGetAndSet(t *testing.T) {
+	file := Parse([]byte(kdeglobals))
+
+	if value, ok := file.Get("General", "ColorScheme"); !ok || value != "BreezeLight" {
+		t.Errorf("want BreezeLight, got %q (%v)", value, ok)
+	}
+	if value, ok := file.Get("General", "font"); !ok || value != "Noto Sans,10" {
+		t.Errorf("want the font, got %q (%v)", value, ok)
+	}
+	if value, ok := file.Get("", "top"); !ok || value != "level" {
+		t.Errorf("want a key outside any section, got %q (%v)", value, ok)
+	}
+	if _, ok := file.Get("Colors:Window", "ColorScheme"); ok {
+		t.Error("keys should not be found in other sections")
+	}
+
+	file.Set("General", "ColorScheme", "BreezeDark")
+	file.Set("General", "font", "Hack,10")
+	file.Set("General", "widgetStyle", "Breeze")
+	file.Set("", "other", "value")
+	file.Set("KDE", "LookAndFeelPackage", "org.kde.breezedark.desktop")
+
+	want := `# Managed by hand
+top=level
+other=value
+
+[General]
+ColorScheme=BreezeDark
+; Fonts are set elsewhere
+font = Hack,10
+widgetStyle=Breeze
+
+# Window colours
+[Colors:Window]
+BackgroundNormal=239,240,241
+
+[KDE]
+LookAndFeelPackage=org.kde.breezedark.desktop
+`
+	if got := string(file.Bytes()); got != want {
+		t.Errorf("unexpected output:\n%v\nwant:\n%v", got, want)
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	for _, data := range []string{"", "\n", "a=b", "[s]\n\n\n", "[s]\nk=v\r\n", "no equals sign\n=\n"} {
+		if got := string(Parse([]byte(data)).Bytes()); got != data {
+			t.Errorf("want %q unchanged, got %q", data, got)
+		}
+	}
+
+	file := Parse(nil)
+	file.Set("Settings", "gtk-application-prefer-dark-theme", "1")
+	if got, want := string(file.Bytes()), "[Settings]\ngtk-application-prefer-dark-theme=1\n"; got != want {
+		t.Errorf("want %q for a new file, got %q", want, got)
+	}
+}
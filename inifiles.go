@@ -0,0 +1,145 @@
+package darkman
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	"gitlab.com/WhyNotHugo/darkman/ini"
+)
+
+// KDE applications reload their palette when this signal is emitted.
+const (
+	kglobalSettingsPath   = "/KGlobalSettings"
+	kglobalSettingsSignal = "org.kde.KGlobalSettings.notifyChange"
+	// From KGlobalSettings::ChangeType.
+	kglobalPaletteChanged = 0
+)
+
+// Returns the keys to set for the given mode, by section.
+func (file *IniFile) ForMode(mode Mode) map[string]map[string]string {
+	switch mode {
+	case DARK:
+		return file.Dark
+	case LIGHT:
+		return file.Light
+	default:
+		return nil
+	}
+}
+
+// Set keys in an INI file, preserving everything else in it. The file is
+// created if it does not exist, and only written if anything changed.
+//
+// Returns true if the file was modified.
+func updateIniFile(path string, sections map[string]map[string]string) (bool, error) {
+	data, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return false, err
+	}
+
+	file := ini.Parse(data)
+
+	// Sort sections and keys, so that new ones are added in a stable order.
+	names := make([]string, 0, len(sections))
+	for name := range sections {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, section := range names {
+		keys := make([]string, 0, len(sections[section]))
+		for key := range sections[section] {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			file.Set(section, key, sections[section][key])
+		}
+	}
+
+	updated := file.Bytes()
+	if string(updated) == string(data) {
+		return false, nil
+	}
+	if err := writeFileAtomic(path, updated, 0644); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// Sets keys in INI-style configuration files on each transition.
+type IniWriter struct {
+	files []IniFile
+	bus   *lazySessionBus
+	// Held while writing, since listeners for consecutive transitions may
+	// run concurrently.
+	mu sync.Mutex
+}
+
+// Creates a new IniWriter for the files in `config`. If any of them requires
+// notifying KDE applications, the session bus is used.
+func NewIniWriter(ctx context.Context, config *Config) *IniWriter {
+	return &IniWriter{
+		files: config.Ini,
+		bus:   &lazySessionBus{ctx: ctx},
+	}
+}
+
+// Notify KDE applications that the palette has changed.
+func (writer *IniWriter) notifyKDE() error {
+	conn, err := writer.bus.Conn()
+	if err != nil {
+		return err
+	}
+	err = conn.Emit(kglobalSettingsPath, kglobalSettingsSignal, int32(kglobalPaletteChanged), int32(0))
+	if err != nil {
+		return fmt.Errorf("failed to emit KGlobalSettings signal: %v", err)
+	}
+	return nil
+}
+
+// Set all keys for `mode` in each file.
+//
+// A failure to update one file does not prevent updating the others.
+func (writer *IniWriter) ChangeMode(mode Mode) error {
+	if mode == NULL || len(writer.files) == 0 {
+		return nil
+	}
+	writer.mu.Lock()
+	defer writer.mu.Unlock()
+
+	var failures []string
+	notify := false
+	for _, file := range writer.files {
+		sections := file.ForMode(mode)
+		if len(sections) == 0 {
+			continue
+		}
+		changed, err := updateIniFile(expandHome(file.File), sections)
+		if err != nil {
+			err = fmt.Errorf("failed to update %v: %v", file.File, err)
+			log.Println(err)
+			failures = append(failures, err.Error())
+			continue
+		}
+		if changed {
+			log.Printf("Updated %v for %v mode.\n", file.File, mode)
+			notify = notify || file.Notify
+		}
+	}
+
+	if notify {
+		if err := writer.notifyKDE(); err != nil {
+			failures = append(failures, err.Error())
+		}
+	}
+
+	if len(failures) > 0 {
+		return fmt.Errorf("failed to update INI files:\n  %v", strings.Join(failures, "\n  "))
+	}
+	return nil
+}
@@ -0,0 +1,136 @@
+package darkman
+
+import (
+	"bufio"
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/godbus/dbus/v5"
+)
+
+// Start a private session bus, and point DBUS_SESSION_BUS_ADDRESS at it.
+func startSessionBus(t *testing.T) string {
+	daemon, err := exec.LookPath("dbus-daemon")
+	if err != nil {
+		t.Skip("dbus-daemon is not available:", err)
+	}
+	cmd := exec.Command(daemon, "--session", "--nofork", "--print-address=1")
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		t.Fatal("failed to create pipe:", err)
+	}
+	if err := cmd.Start(); err != nil {
+		t.Fatal("failed to start dbus-daemon:", err)
+	}
+	t.Cleanup(func() {
+		cmd.Process.Kill()
+		cmd.Wait()
+	})
+
+	address, err := bufio.NewReader(stdout).ReadString('\n')
+	if err != nil {
+		t.Fatal("failed to read bus address:", err)
+	}
+	address = strings.TrimSpace(address)
+	t.Setenv("DBUS_SESSION_BUS_ADDRESS", address)
+	return address
+}
+
+func TestIniWriter(t *testing.T) {
+	address := startSessionBus(t)
+	listener, err := dbus.Connect(address)
+	if err != nil {
+		t.Fatal("failed to connect to bus:", err)
+	}
+	defer listener.Close()
+	if err := listener.AddMatchSignal(dbus.WithMatchInterface("org.kde.KGlobalSettings")); err != nil {
+		t.Fatal("failed to add match:", err)
+	}
+	signals := make(chan *dbus.Signal, 10)
+	listener.Signal(signals)
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "kdeglobals")
+	if err := os.WriteFile(path, []byte("# Comment\n[General]\nColorScheme=BreezeLight\nother=1\n"), 0600); err != nil {
+		t.Fatal("failed to write test file:", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	writer := NewIniWriter(ctx, &Config{Ini: []IniFile{{
+		File:   path,
+		Notify: true,
+		Dark:   map[string]map[string]string{"General": {"ColorScheme": "BreezeDark"}},
+		Light:  map[string]map[string]string{"General": {"ColorScheme": "BreezeLight"}},
+	}}})
+
+	if err := writer.ChangeMode(DARK); err != nil {
+		t.Fatal("failed to apply dark mode:", err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal("failed to read file:", err)
+	}
+	if want := "# Comment\n[General]\nColorScheme=BreezeDark\nother=1\n"; string(data) != want {
+		t.Errorf("want %q, got %q", want, data)
+	}
+
+	select {
+	case signal := <-signals:
+		if signal.Name != kglobalSettingsSignal || len(signal.Body) != 2 || signal.Body[0] != int32(kglobalPaletteChanged) {
+			t.Errorf("unexpected signal: %v", signal)
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("did not receive notifyChange signal")
+	}
+
+	// Applying the same mode again changes nothing, and sends no signal.
+	if err := writer.ChangeMode(DARK); err != nil {
+		t.Fatal("failed to apply dark mode:", err)
+	}
+	select {
+	case signal := <-signals:
+		t.Errorf("unexpected signal for an unchanged file: %v", signal)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestIniWriterSymlink(t *testing.T) {
+	dir := t.TempDir()
+	real := filepath.Join(dir, "dotfiles", "kdeglobals")
+	if err := os.Mkdir(filepath.Dir(real), 0755); err != nil {
+		t.Fatal("failed to create directory:", err)
+	}
+	if err := os.WriteFile(real, []byte("[General]\nColorScheme=BreezeLight\n"), 0600); err != nil {
+		t.Fatal("failed to write test file:", err)
+	}
+	path := filepath.Join(dir, "kdeglobals")
+	if err := os.Symlink("dotfiles/kdeglobals", path); err != nil {
+		t.Fatal("failed to create symlink:", err)
+	}
+
+	writer := NewIniWriter(context.Background(), &Config{Ini: []IniFile{{
+		File: path,
+		Dark: map[string]map[string]string{"General": {"ColorScheme": "BreezeDark"}},
+	}}})
+	if err := writer.ChangeMode(DARK); err != nil {
+		t.Fatal("failed to apply dark mode:", err)
+	}
+
+	// The symlink is kept, and the file it points to is updated.
+	if target, err := os.Readlink(path); err != nil || target != "dotfiles/kdeglobals" {
+		t.Errorf("want symlink kept, got %q (%v)", target, err)
+	}
+	data, err := os.ReadFile(real)
+	if err != nil {
+		t.Fatal("failed to read file:", err)
+	}
+	if want := "[General]\nColorScheme=BreezeDark\n"; string(data) != want {
+		t.Errorf("want %q, got %q", want, data)
+	}
+}
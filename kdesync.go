@@ -0,0 +1,166 @@
+package darkman
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/adrg/xdg"
+	"github.com/godbus/dbus/v5"
+	"gitlab.com/WhyNotHugo/darkman/ini"
+)
+
+// KDE applications emit this signal after changing kdeglobals.
+const (
+	kconfigNotifyPath   = "/kdeglobals"
+	kconfigNotifySignal = "org.kde.kconfig.notify.ConfigChanged"
+)
+
+// Returns the mode which matches the colour scheme in a kdeglobals file,
+// based on the luminance of the window background. Without any colours, the
+// file uses Plasma's default scheme, which is light.
+func kdeColorSchemeMode(data []byte) (Mode, error) {
+	background, ok := ini.Parse(data).Get("Colors:Window", "BackgroundNormal")
+	if !ok {
+		return LIGHT, nil
+	}
+
+	// Colours are written as "r,g,b", optionally followed by an alpha value.
+	components := strings.Split(background, ",")
+	if len(components) < 3 {
+		return NULL, fmt.Errorf("invalid colour: %q", background)
+	}
+	var rgb [3]float64
+	for i := range rgb {
+		value, err := strconv.ParseUint(strings.TrimSpace(components[i]), 10, 8)
+		if err != nil {
+			return NULL, fmt.Errorf("invalid colour: %q", background)
+		}
+		rgb[i] = float64(value) / 255
+	}
+
+	luma := 0.299*rgb[0] + 0.587*rgb[1] + 0.114*rgb[2]
+	if luma < 0.5 {
+		return DARK, nil
+	}
+	return LIGHT, nil
+}
+
+// Follows changes to KDE Plasma's colour scheme, and switches to the matching
+// mode.
+//
+// Only changes to the scheme itself are applied, so darkman's own transitions
+// are not reverted when unrelated settings in kdeglobals change.
+type KdeSync struct {
+	path     string
+	override func(Mode)
+	// The mode of the colour scheme when kdeglobals was last read.
+	scheme Mode
+	// darkman's current mode.
+	current Mode
+	mu      sync.Mutex
+}
+
+// Creates a new KdeSync. Changes to the colour scheme are passed to
+// `override`.
+func NewKdeSync(override func(Mode)) *KdeSync {
+	return &KdeSync{
+		path:     filepath.Join(xdg.ConfigHome, "kdeglobals"),
+		override: override,
+		scheme:   NULL,
+		current:  NULL,
+	}
+}
+
+// Read kdeglobals, and apply the colour scheme's mode if it has changed.
+func (kde *KdeSync) check() {
+	data, err := os.ReadFile(kde.path)
+	if err != nil && !os.IsNotExist(err) {
+		log.Println("Could not read KDE colour scheme:", err)
+		return
+	}
+	mode, err := kdeColorSchemeMode(data)
+	if err != nil {
+		log.Printf("Could not read KDE colour scheme from %v: %v\n", kde.path, err)
+		return
+	}
+
+	kde.mu.Lock()
+	if mode == kde.scheme {
+		kde.mu.Unlock()
+		return
+	}
+	initial := kde.scheme == NULL
+	kde.scheme = mode
+	current := kde.current
+	kde.mu.Unlock()
+
+	if initial || mode == current {
+		return
+	}
+	log.Printf("KDE colour scheme changed to %v mode.\n", mode)
+	kde.override(mode)
+}
+
+// Start following changes to kdeglobals, until `ctx` is cancelled.
+//
+// Changes are detected via inotify, and via the D-Bus signal which KDE
+// applications emit after changing their settings. The latter is optional, and
+// only logged if the session bus is not available. If the connection to the
+// session bus is lost, it reconnects.
+func (kde *KdeSync) Start(ctx context.Context) error {
+	// Only changes after this point are applied.
+	kde.check()
+
+	watcher, err := newDirWatcher(ctx)
+	if err != nil {
+		return err
+	}
+	if err := watcher.Add(filepath.Dir(kde.path)); err != nil {
+		return err
+	}
+
+	subscribe := func(conn *dbus.Conn) error {
+		return conn.AddMatchSignal(
+			dbus.WithMatchObjectPath(kconfigNotifyPath),
+			dbus.WithMatchInterface("org.kde.kconfig.notify"),
+			dbus.WithMatchMember("ConfigChanged"),
+		)
+	}
+	err = followSignals(ctx, "KDE configuration changes", subscribe, func(signal *dbus.Signal) {
+		if signal.Name == kconfigNotifySignal {
+			kde.check()
+		}
+	})
+	if err != nil {
+		log.Println("Could not listen for KDE configuration changes:", err)
+	}
+
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case path := <-watcher.Events:
+				if path == kde.path {
+					kde.check()
+				}
+			}
+		}
+	}()
+
+	return nil
+}
+
+// Keep track of darkman's current mode.
+func (kde *KdeSync) ChangeMode(mode Mode) error {
+	kde.mu.Lock()
+	defer kde.mu.Unlock()
+	kde.current = mode
+	return nil
+}
@@ -0,0 +1,155 @@
+package darkman
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/godbus/dbus/v5"
+)
+
+const breezeDark = "[Colors:Window]\nBackgroundNormal=32,35,38\nForegroundNormal=252,252,252\n"
+const breezeLight = "[Colors:Window]\nBackgroundNormal=239,240,241\nForegroundNormal=35,38,41\n"
+
+func TestKdeColorSchemeMode(t *testing.T) {
+	cases := map[string]Mode{
+		breezeDark:                               DARK,
+		breezeLight:                              LIGHT,
+		"[General]\nColorScheme=BreezeClassic\n": LIGHT,
+		"[Colors:Window]\nBackgroundNormal=20,20,20,255\n": DARK,
+	}
+	for data, want := range cases {
+		if got, err := kdeColorSchemeMode([]byte(data)); err != nil || got != want {
+			t.Errorf("%q: want %v, got %v (%v)", data, want, got, err)
+		}
+	}
+
+	if _, err := kdeColorSchemeMode([]byte("[Colors:Window]\nBackgroundNormal=#202020\n")); err == nil {
+		t.Error("want an error for an invalid colour")
+	}
+}
+
+// Wait for a single mode to be applied.
+func expectOverride(t *testing.T, overrides chan Mode, want Mode) {
+	t.Helper()
+	select {
+	case mode := <-overrides:
+		if mode != want {
+			t.Errorf("want %v mode applied, got %v", want, mode)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for a change to be applied")
+	}
+}
+
+func TestKdeSyncCheck(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "kdeglobals")
+	write := func(data string) {
+		if err := os.WriteFile(path, []byte(data), 0600); err != nil {
+			t.Fatal("failed to write test file:", err)
+		}
+	}
+
+	overrides := make(chan Mode, 10)
+	kde := NewKdeSync(func(mode Mode) { overrides <- mode })
+	kde.path = path
+	kde.ChangeMode(LIGHT)
+
+	// The initial scheme is never applied.
+	write(breezeDark)
+	kde.check()
+	// Nor is a scheme which matches the current mode.
+	write(breezeLight)
+	kde.check()
+
+	write(breezeDark)
+	kde.check()
+	expectOverride(t, overrides, DARK)
+	kde.ChangeMode(DARK)
+
+	// darkman's own transitions are not reverted by unrelated changes.
+	kde.ChangeMode(LIGHT)
+	write(breezeDark + "[General]\nfont=Noto Sans\n")
+	kde.check()
+	// Nor re-applied when the scheme catches up.
+	write(breezeLight)
+	kde.check()
+
+	select {
+	case mode := <-overrides:
+		t.Errorf("want no further changes applied, got %v", mode)
+	default:
+	}
+}
+
+func TestKdeSyncWatch(t *testing.T) {
+	startSessionBus(t)
+	path := filepath.Join(t.TempDir(), "kdeglobals")
+	if err := os.WriteFile(path, []byte(breezeLight), 0600); err != nil {
+		t.Fatal("failed to write test file:", err)
+	}
+
+	overrides := make(chan Mode, 10)
+	kde := NewKdeSync(func(mode Mode) { overrides <- mode })
+	kde.path = path
+	kde.ChangeMode(LIGHT)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if err := kde.Start(ctx); err != nil {
+		t.Fatal("failed to start:", err)
+	}
+
+	if err := os.WriteFile(path, []byte(breezeDark), 0600); err != nil {
+		t.Fatal("failed to write test file:", err)
+	}
+	expectOverride(t, overrides, DARK)
+}
+
+func TestKdeSyncSignal(t *testing.T) {
+	address := startSessionBus(t)
+	conn, err := dbus.Connect(address)
+	if err != nil {
+		t.Fatal("failed to connect to bus:", err)
+	}
+	defer conn.Close()
+
+	// Changes to the target of a symlink are not seen via inotify, so only the
+	// D-Bus signal reveals them.
+	dir := t.TempDir()
+	target := filepath.Join(dir, "dotfiles", "kdeglobals")
+	path := filepath.Join(dir, "config", "kdeglobals")
+	for _, d := range []string{filepath.Dir(target), filepath.Dir(path)} {
+		if err := os.Mkdir(d, 0700); err != nil {
+			t.Fatal("failed to create directory:", err)
+		}
+	}
+	if err := os.WriteFile(target, []byte(breezeLight), 0600); err != nil {
+		t.Fatal("failed to write test file:", err)
+	}
+	if err := os.Symlink(target, path); err != nil {
+		t.Fatal("failed to create symlink:", err)
+	}
+
+	overrides := make(chan Mode, 10)
+	kde := NewKdeSync(func(mode Mode) { overrides <- mode })
+	kde.path = path
+	kde.ChangeMode(LIGHT)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if err := kde.Start(ctx); err != nil {
+		t.Fatal("failed to start:", err)
+	}
+
+	if err := os.WriteFile(target, []byte(breezeDark), 0600); err != nil {
+		t.Fatal("failed to write test file:", err)
+	}
+	changed := map[string][][]byte{"Colors:Window": {[]byte("BackgroundNormal")}}
+	if err := conn.Emit(kconfigNotifyPath, kconfigNotifySignal, changed); err != nil {
+		t.Fatal("failed to emit signal:", err)
+	}
+	expectOverride(t, overrides, DARK)
+}
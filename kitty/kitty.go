@@ -0,0 +1,168 @@
+// Package kitty implements a minimal client for kitty's remote control
+// protocol.
+//
+// See: https://sw.kovidgoyal.net/kitty/rc_protocol/
+package kitty
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Messages are framed as DCS escape sequences.
+const (
+	messagePrefix = "\x1bP@kitty-cmd"
+	messageSuffix = "\x1b\\"
+)
+
+// Version of the protocol spoken by this client.
+var protocolVersion = []int{0, 26, 0}
+
+// A command sent to kitty.
+type command struct {
+	Cmd     string      `json:"cmd"`
+	Version []int       `json:"version"`
+	Payload interface{} `json:"payload,omitempty"`
+}
+
+// Kitty's reply to a command.
+type response struct {
+	Ok    bool   `json:"ok"`
+	Error string `json:"error,omitempty"`
+}
+
+// Payload for the set-colors command.
+type setColors struct {
+	Colors     map[string]interface{} `json:"colors"`
+	All        bool                   `json:"all"`
+	Configured bool                   `json:"configured"`
+}
+
+// Parse a colour in the "#rgb" or "#rrggbb" format, as used in theme files.
+func parseColor(value string) (int, bool) {
+	if !strings.HasPrefix(value, "#") {
+		return 0, false
+	}
+	hex := value[1:]
+	if len(hex) == 3 {
+		hex = string([]byte{hex[0], hex[0], hex[1], hex[1], hex[2], hex[2]})
+	}
+	if len(hex) != 6 {
+		return 0, false
+	}
+	color, err := strconv.ParseUint(hex, 16, 32)
+	if err != nil {
+		return 0, false
+	}
+	return int(color), true
+}
+
+// Parse a kitty theme file, and return its colours in the format used by the
+// set-colors command.
+//
+// Colours are returned as integers, except for those set to "none", which are
+// nil. Any other settings in the file are ignored.
+func ParseTheme(r io.Reader) (map[string]interface{}, error) {
+	colors := make(map[string]interface{})
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 2 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		if fields[1] == "none" {
+			colors[fields[0]] = nil
+		} else if color, ok := parseColor(fields[1]); ok {
+			colors[fields[0]] = color
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(colors) == 0 {
+		return nil, fmt.Errorf("no colours found")
+	}
+	return colors, nil
+}
+
+// Returns the address to connect to, without the "unix:" prefix used in
+// kitty's configuration. Abstract sockets start with "@".
+func SocketAddress(socket string) string {
+	return strings.TrimPrefix(socket, "unix:")
+}
+
+// Send a command to the kitty instance listening on `socket`, and wait for
+// its response. The whole exchange must complete within `timeout`.
+func send(socket string, cmd command, timeout time.Duration) error {
+	conn, err := net.DialTimeout("unix", SocketAddress(socket), timeout)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if err := conn.SetDeadline(time.Now().Add(timeout)); err != nil {
+		return err
+	}
+
+	message, err := json.Marshal(cmd)
+	if err != nil {
+		return fmt.Errorf("failed to encode command: %v", err)
+	}
+	if _, err := conn.Write([]byte(messagePrefix + string(message) + messageSuffix)); err != nil {
+		return fmt.Errorf("failed to send command: %v", err)
+	}
+
+	reply, err := readMessage(bufio.NewReader(conn))
+	if err != nil {
+		return fmt.Errorf("failed to read response: %v", err)
+	}
+	var resp response
+	if err := json.Unmarshal(reply, &resp); err != nil {
+		return fmt.Errorf("invalid response: %v", err)
+	}
+	if !resp.Ok {
+		return fmt.Errorf("%v failed: %v", cmd.Cmd, resp.Error)
+	}
+	return nil
+}
+
+// Read a single framed message, and return its JSON body.
+func readMessage(r *bufio.Reader) ([]byte, error) {
+	var buf []byte
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		buf = append(buf, b)
+		if bytes.HasSuffix(buf, []byte(messageSuffix)) {
+			break
+		}
+	}
+
+	start := bytes.Index(buf, []byte(messagePrefix))
+	if start < 0 {
+		return nil, fmt.Errorf("malformed message: %q", buf)
+	}
+	return buf[start+len(messagePrefix) : len(buf)-len(messageSuffix)], nil
+}
+
+// Apply colours to all windows of the kitty instance listening on `socket`.
+//
+// The colours also become the instance's configured colours, so that they are
+// used for any new windows.
+func SetColors(socket string, colors map[string]interface{}, timeout time.Duration) error {
+	return send(socket, command{
+		Cmd:     "set-colors",
+		Version: protocolVersion,
+		Payload: setColors{Colors: colors, All: true, Configured: true},
+	}, timeout)
+}
@@ -0,0 +1,100 @@
+package kitty
+
+import (
+	"bufio"
+	"encoding/json"
+	"net"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+const theme = `# vim:ft=kitty
+## name: Test theme
+
+foreground  #c0caf5
+background #1a1b26
+selection_foreground none
+color1 #f00
+include other.conf
+background_opacity 0.9
+`
+
+func TestParseTheme(t *testing.T) {
+	colors, err := ParseTheme(strings.NewReader(theme))
+	if err != nil {
+		t.Fatal("failed to parse theme:", err)
+	}
+	want := map[string]interface{}{
+		"foreground":           0xc0caf5,
+		"background":           0x1a1b26,
+		"selection_foreground": nil,
+		"color1":               0xff0000,
+	}
+	if !reflect.DeepEqual(colors, want) {
+		t.Errorf("want %v, got %v", want, colors)
+	}
+
+	if _, err := ParseTheme(strings.NewReader("font_size 12\n")); err == nil {
+		t.Error("want an error for a file without colours")
+	}
+}
+
+func TestSetColors(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "kitty-1234")
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal("failed to listen:", err)
+	}
+	defer listener.Close()
+
+	received := make(chan map[string]interface{}, 2)
+	go func() {
+		for i := 0; i < 2; i++ {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			message, err := readMessage(bufio.NewReader(conn))
+			if err != nil {
+				conn.Close()
+				return
+			}
+			var cmd map[string]interface{}
+			json.Unmarshal(message, &cmd)
+			received <- cmd
+
+			reply := `{"ok": true}`
+			if i == 1 {
+				reply = `{"ok": false, "error": "Remote control is disabled"}`
+			}
+			conn.Write([]byte(messagePrefix + reply + messageSuffix))
+			conn.Close()
+		}
+	}()
+
+	colors := map[string]interface{}{"background": 0x1a1b26, "selection_foreground": nil}
+	if err := SetColors("unix:"+path, colors, time.Second); err != nil {
+		t.Fatal("failed to set colours:", err)
+	}
+
+	cmd := <-received
+	if cmd["cmd"] != "set-colors" {
+		t.Errorf("want set-colors, got %v", cmd["cmd"])
+	}
+	payload, _ := cmd["payload"].(map[string]interface{})
+	wantPayload := map[string]interface{}{
+		"colors":     map[string]interface{}{"background": float64(0x1a1b26), "selection_foreground": nil},
+		"all":        true,
+		"configured": true,
+	}
+	if !reflect.DeepEqual(payload, wantPayload) {
+		t.Errorf("want payload %v, got %v", wantPayload, payload)
+	}
+
+	if err := SetColors(path, colors, time.Second); err == nil || !strings.Contains(err.Error(), "Remote control is disabled") {
+		t.Errorf("want error from kitty, got %v", err)
+	}
+}
@@ -0,0 +1,114 @@
+package darkman
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+
+	"gitlab.com/WhyNotHugo/darkman/kitty"
+)
+
+// Returns the path to the theme file for the given mode.
+func (config *KittyConfig) ForMode(mode Mode) string {
+	switch mode {
+	case DARK:
+		return expandHome(config.Dark)
+	case LIGHT:
+		return expandHome(config.Light)
+	default:
+		return ""
+	}
+}
+
+// Returns the sockets of all running kitty instances, expanding any glob
+// patterns. Abstract sockets (starting with "@") are returned as they are.
+func (config *KittyConfig) FindSockets() []string {
+	found := make(map[string]bool)
+	for _, socket := range config.Sockets {
+		address := kitty.SocketAddress(socket)
+		if strings.HasPrefix(address, "@") {
+			found[address] = true
+			continue
+		}
+		matches, err := filepath.Glob(expandHome(address))
+		if err != nil {
+			log.Printf("Invalid kitty socket pattern %v: %v\n", socket, err)
+			continue
+		}
+		for _, match := range matches {
+			found[match] = true
+		}
+	}
+
+	sockets := make([]string, 0, len(found))
+	for socket := range found {
+		sockets = append(sockets, socket)
+	}
+	sort.Strings(sockets)
+	return sockets
+}
+
+// Applies a colour theme to all running kitty instances on each transition.
+type KittyThemer struct {
+	config KittyConfig
+}
+
+// Creates a new KittyThemer using the settings in `config`.
+func NewKittyThemer(config *Config) *KittyThemer {
+	return &KittyThemer{config: config.Kitty}
+}
+
+// Apply the theme for `mode` to all running kitty instances.
+//
+// Instances are updated concurrently, so an unresponsive one does not delay
+// others. Sockets left behind by instances which are no longer running are
+// ignored.
+func (themer *KittyThemer) ChangeMode(mode Mode) error {
+	path := themer.config.ForMode(mode)
+	if path == "" || len(themer.config.Sockets) == 0 {
+		return nil
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open kitty theme: %v", err)
+	}
+	colors, err := kitty.ParseTheme(file)
+	file.Close()
+	if err != nil {
+		return fmt.Errorf("failed to parse kitty theme %v: %v", path, err)
+	}
+
+	var failures []string
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, socket := range themer.config.FindSockets() {
+		wg.Add(1)
+		go func(socket string) {
+			defer wg.Done()
+			err := kitty.SetColors(socket, colors, themer.config.Timeout)
+			if errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, syscall.ENOENT) {
+				log.Printf("Ignoring stale kitty socket %v.\n", socket)
+				return
+			} else if err != nil {
+				mu.Lock()
+				failures = append(failures, fmt.Sprintf("%v: %v", socket, err))
+				mu.Unlock()
+				return
+			}
+			log.Printf("Applied %v mode to kitty at %v.\n", mode, socket)
+		}(socket)
+	}
+	wg.Wait()
+
+	if len(failures) > 0 {
+		return fmt.Errorf("failed to update kitty:\n  %v", strings.Join(failures, "\n  "))
+	}
+	return nil
+}
@@ -5,12 +5,14 @@
 package libdarkman
 
 import (
+	"errors"
 	"fmt"
 
 	"github.com/godbus/dbus/v5"
 )
 
-const prop = "nl.whynothugo.darkman.Mode"
+const iface = "nl.whynothugo.darkman"
+const prop = iface + ".Mode"
 
 func getDBusObj() (*dbus.BusObject, error) {
 	conn, err := dbus.ConnectSessionBus()
@@ -31,8 +33,21 @@ func validateMode(mode string) error {
 	return nil
 }
 
+// Returns true if the service does not implement a method, which is the case
+// for versions of darkman which predate it.
+func isUnknownMethod(err error) bool {
+	var dbusErr dbus.Error
+	return errors.As(err, &dbusErr) && dbusErr.Name == "org.freedesktop.DBus.Error.UnknownMethod"
+}
+
 // Set the current mode. Mode MUST be either "light" or "dark".
 func SetMode(mode string) error {
+	return SetModeWithReason(mode, "")
+}
+
+// Set the current mode. Mode MUST be either "light" or "dark". The reason for
+// the change is shown in darkman's logs.
+func SetModeWithReason(mode string, reason string) error {
 	if err := validateMode(mode); err != nil {
 		return err
 	}
@@ -42,8 +57,12 @@ func SetMode(mode string) error {
 		return err
 	}
 
-	if err = (*obj).SetProperty(prop, dbus.MakeVariant(mode)); err != nil {
-		return fmt.Errorf("error setting property: %v", err)
+	err = (*obj).Call(iface+".SetMode", 0, mode, reason).Err
+	if isUnknownMethod(err) {
+		err = (*obj).SetProperty(prop, dbus.MakeVariant(mode))
+	}
+	if err != nil {
+		return fmt.Errorf("error setting mode: %v", err)
 	}
 
 	return nil
@@ -58,13 +77,42 @@ func GetMode() (string, error) {
 		return "", err
 	}
 
-	if err = (*obj).StoreProperty(prop, &mode); err != nil {
-		return "", fmt.Errorf("error reading property: %v", err)
+	var state map[string]dbus.Variant
+	err = (*obj).Call(iface+".GetState", 0).Store(&state)
+	if isUnknownMethod(err) {
+		err = (*obj).StoreProperty(prop, &mode)
+	} else if err == nil {
+		err = state["mode"].Store(&mode)
+	}
+	if err != nil {
+		return "", fmt.Errorf("error reading mode: %v", err)
 	}
 
 	return mode, nil
 }
 
+// Returns the state of the service. It always includes the current mode as
+// "mode", and the times of the next sunrise and sunset (as Unix timestamps) as
+// "sunrise" and "sunset" if they are known.
+func GetState() (map[string]interface{}, error) {
+	var variants map[string]dbus.Variant
+
+	obj, err := getDBusObj()
+	if err != nil {
+		return nil, err
+	}
+
+	if err = (*obj).Call(iface+".GetState", 0).Store(&variants); err != nil {
+		return nil, fmt.Errorf("error calling GetState: %v", err)
+	}
+
+	state := make(map[string]interface{}, len(variants))
+	for key, value := range variants {
+		state[key] = value.Value()
+	}
+	return state, nil
+}
+
 // Toggle the current mode (e.g.: switch to light mode if the current mode is
 // dark mode or viceversa).
 // Returns the current mode, either "light" or "dark".
@@ -76,7 +124,23 @@ func ToggleMode() (string, error) {
 		return "", err
 	}
 
-	if err = (*obj).StoreProperty(prop, &mode); err != nil {
+	err = (*obj).Call(iface+".Toggle", 0).Store(&mode)
+	if isUnknownMethod(err) {
+		return toggleViaProperty(obj)
+	}
+	if err != nil {
+		return "", fmt.Errorf("error calling Toggle: %v", err)
+	}
+
+	return mode, nil
+}
+
+// Toggle the current mode by reading and writing the Mode property. This is
+// not atomic, and only used with versions of darkman which lack Toggle.
+func toggleViaProperty(obj *dbus.BusObject) (string, error) {
+	var mode string
+
+	if err := (*obj).StoreProperty(prop, &mode); err != nil {
 		return "", fmt.Errorf("error reading property: %v", err)
 	}
 
@@ -86,9 +150,80 @@ func ToggleMode() (string, error) {
 		mode = "light"
 	}
 
-	if err = (*obj).SetProperty(prop, dbus.MakeVariant(mode)); err != nil {
+	if err := (*obj).SetProperty(prop, dbus.MakeVariant(mode)); err != nil {
 		return "", fmt.Errorf("error setting property: %v", err)
 	}
 
 	return mode, nil
 }
+
+// Run all transition scripts for the current mode again. If script is not
+// empty, only the script with that name is run.
+//
+// This is useful when an application starts and needs the current mode to be
+// applied to it.
+func Reapply(script string) error {
+	obj, err := getDBusObj()
+	if err != nil {
+		return err
+	}
+
+	if err = (*obj).Call("nl.whynothugo.darkman.Reapply", 0, script).Err; err != nil {
+		return fmt.Errorf("error calling Reapply: %v", err)
+	}
+
+	return nil
+}
+
+// Make the running service read its configuration again.
+//
+// Problems with the new configuration are returned as an error.
+func Reload() error {
+	obj, err := getDBusObj()
+	if err != nil {
+		return err
+	}
+
+	if err = (*obj).Call(iface+".Reload", 0).Err; err != nil {
+		return fmt.Errorf("error calling Reload: %v", err)
+	}
+
+	return nil
+}
+
+// Read a property of the running service into `value`. The service is not
+// started via D-Bus activation if it is not running.
+func getRunningProperty(name string, value interface{}) error {
+	obj, err := getDBusObj()
+	if err != nil {
+		return err
+	}
+
+	var variant dbus.Variant
+	err = (*obj).Call("org.freedesktop.DBus.Properties.Get", dbus.FlagNoAutoStart, iface, name).Store(&variant)
+	if err != nil {
+		return err
+	}
+	return dbus.Store([]interface{}{variant.Value()}, value)
+}
+
+// Returns the version of the running service. Unlike other functions, this
+// does not start the service if it is not running.
+func GetVersion() (string, error) {
+	var version string
+	if err := getRunningProperty("Version", &version); err != nil {
+		return "", fmt.Errorf("error reading version: %v", err)
+	}
+	return version, nil
+}
+
+// Returns the names of the subsystems enabled in the running service (e.g.:
+// "portal" or "geoclue"). Like GetVersion, this does not start the service if
+// it is not running.
+func GetCapabilities() ([]string, error) {
+	var capabilities []string
+	if err := getRunningProperty("Capabilities", &capabilities); err != nil {
+		return nil, fmt.Errorf("error reading capabilities: %v", err)
+	}
+	return capabilities, nil
+}
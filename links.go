@@ -0,0 +1,130 @@
+package darkman
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// Makes the temporary names of new symlinks unique within the process.
+var linkCounter uint64
+
+// Returns the target for the given mode, with a leading "~/" expanded.
+func (link *Link) Target(mode Mode) string {
+	switch mode {
+	case DARK:
+		return expandHome(link.Dark)
+	case LIGHT:
+		return expandHome(link.Light)
+	default:
+		return ""
+	}
+}
+
+// Returns the path that a link target refers to. Relative targets are
+// relative to the directory containing the link, just like with symlinks.
+func (link *Link) resolve(target string) string {
+	if filepath.IsAbs(target) {
+		return target
+	}
+	return filepath.Join(filepath.Dir(expandHome(link.Link)), target)
+}
+
+// Returns the mode which the link currently points to, and its current target.
+//
+// The mode is NULL if the link points elsewhere. Returns an error if the link
+// does not exist or is not a symlink.
+func (link *Link) Status() (Mode, string, error) {
+	target, err := os.Readlink(expandHome(link.Link))
+	if err != nil {
+		return NULL, "", err
+	}
+	switch target {
+	case link.Target(DARK):
+		return DARK, target, nil
+	case link.Target(LIGHT):
+		return LIGHT, target, nil
+	default:
+		return NULL, target, nil
+	}
+}
+
+// Point a symlink at `target`, atomically replacing it if it exists.
+//
+// A new symlink is created under a temporary name and renamed over the
+// existing one, so that applications never find the link missing. Regular
+// files are never replaced.
+func switchLink(path string, target string) error {
+	info, err := os.Lstat(path)
+	if err == nil && info.Mode()&os.ModeSymlink == 0 {
+		return fmt.Errorf("refusing to replace %v, which is not a symlink", path)
+	} else if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	if current, err := os.Readlink(path); err == nil && current == target {
+		return nil
+	}
+
+	n := atomic.AddUint64(&linkCounter, 1)
+	tmp := filepath.Join(filepath.Dir(path), fmt.Sprintf(".%v.darkman-%d-%d", filepath.Base(path), os.Getpid(), n))
+	if err := os.Remove(tmp); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err := os.Symlink(target, tmp); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+// Switches symlinks between a dark and light target on each transition.
+type LinkSwitcher struct {
+	links []Link
+	// Held while switching, since listeners for consecutive transitions may
+	// run concurrently.
+	mu sync.Mutex
+}
+
+// Creates a new LinkSwitcher for the links in `config`.
+func NewLinkSwitcher(config *Config) *LinkSwitcher {
+	return &LinkSwitcher{links: config.Links}
+}
+
+// Point all links at their target for `mode`.
+//
+// A failure to switch one link does not prevent switching the others.
+func (switcher *LinkSwitcher) ChangeMode(mode Mode) error {
+	if mode == NULL || len(switcher.links) == 0 {
+		return nil
+	}
+	switcher.mu.Lock()
+	defer switcher.mu.Unlock()
+
+	var failures []string
+	for _, link := range switcher.links {
+		target := link.Target(mode)
+		if target == "" {
+			continue
+		}
+		if err := switchLink(expandHome(link.Link), target); err != nil {
+			err = fmt.Errorf("failed to switch link %v: %v", link.Link, err)
+			log.Println(err)
+			failures = append(failures, err.Error())
+			continue
+		}
+		log.Printf("Pointed %v at %v.\n", link.Link, target)
+	}
+
+	if len(failures) > 0 {
+		return fmt.Errorf("failed to switch %d link(s):\n  %v", len(failures), strings.Join(failures, "\n  "))
+	}
+	return nil
+}
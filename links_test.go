@@ -0,0 +1,113 @@
+package darkman
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestLinkSwitcher(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"theme-dark.conf", "theme-light.conf", "regular.conf"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(name), 0644); err != nil {
+			t.Fatal("failed to write test file:", err)
+		}
+	}
+
+	link := Link{
+		Link:  filepath.Join(dir, "theme.conf"),
+		Dark:  "theme-dark.conf",
+		Light: filepath.Join(dir, "theme-light.conf"),
+	}
+	config := Config{Links: []Link{
+		link,
+		{Link: filepath.Join(dir, "regular.conf"), Dark: "theme-dark.conf", Light: "theme-light.conf"},
+	}}
+	if err := config.Validate(); err == nil {
+		t.Error("want an error for a link which is a regular file")
+	}
+
+	switcher := NewLinkSwitcher(&config)
+	for _, mode := range []Mode{DARK, LIGHT, LIGHT} {
+		// The regular file is never replaced, but other links still switch.
+		if err := switcher.ChangeMode(mode); err == nil {
+			t.Error("want an error when replacing a regular file")
+		}
+
+		current, target, err := link.Status()
+		if err != nil {
+			t.Fatal("failed to read link status:", err)
+		}
+		if current != mode || target != link.Target(mode) {
+			t.Errorf("want link pointing to %v mode, got %v (%v)", mode, current, target)
+		}
+		data, err := os.ReadFile(link.Link)
+		if err != nil || string(data) != "theme-"+string(mode)+".conf" {
+			t.Errorf("want link to resolve to the %v theme, got %q (%v)", mode, data, err)
+		}
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, "regular.conf"))
+	if err != nil || string(data) != "regular.conf" {
+		t.Errorf("regular file should be unchanged, got %q (%v)", data, err)
+	}
+
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 4 {
+		t.Errorf("want no leftover temporary links, got %v", entries)
+	}
+}
+
+func TestLinkSwitcherConcurrent(t *testing.T) {
+	dir := t.TempDir()
+	link := Link{Link: filepath.Join(dir, "theme.conf"), Dark: "theme-dark.conf", Light: "theme-light.conf"}
+	switcher := NewLinkSwitcher(&Config{Links: []Link{link}})
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 20)
+	for i := 0; i < 20; i++ {
+		mode := DARK
+		if i%2 == 0 {
+			mode = LIGHT
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs <- switcher.ChangeMode(mode)
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		if err != nil {
+			t.Error("want no errors switching concurrently, got", err)
+		}
+	}
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 1 {
+		t.Errorf("want no leftover temporary links, got %v", entries)
+	}
+}
+
+func TestLinkSwitcherOrdering(t *testing.T) {
+	dir := t.TempDir()
+	link := Link{Link: filepath.Join(dir, "theme.conf"), Dark: "theme-dark.conf", Light: "theme-light.conf"}
+	service := NewService(LIGHT)
+	service.AddListener(NewLinkSwitcher(&Config{Links: []Link{link}}).ChangeMode)
+
+	// Listeners for quick consecutive transitions may start in any order, but
+	// the link must always end up pointing at the final mode.
+	for i := 0; i < 20; i++ {
+		service.OverrideMode(DARK)
+		service.OverrideMode(LIGHT)
+	}
+	service.OverrideMode(DARK)
+
+	time.Sleep(100 * time.Millisecond)
+	if current, target, err := link.Status(); err != nil || current != service.CurrentMode() {
+		t.Errorf("want link pointing to %v mode, got %v (%v, %v)", service.CurrentMode(), current, target, err)
+	}
+}
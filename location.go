@@ -20,7 +20,21 @@ import (
 // Errors here are hard to handle, since we can't know geoclue's state, and we
 // can't control it and tell it to stop either.
 
-func saveLocationToCache(loc geoclue.Location) error {
+// Sources from which a location may be obtained.
+const (
+	LocationFromConfig  = "config"
+	LocationFromGeoclue = "geoclue"
+	LocationFromDBus    = "dbus"
+)
+
+// A location, along with where and when it was obtained.
+type LocationInfo struct {
+	geoclue.Location
+	Source    string    `json:"source"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+func saveLocationToCache(loc LocationInfo) error {
 	cacheFilePath, err := xdg.CacheFile("darkman/location.json")
 	if err != nil {
 		return err
@@ -36,7 +50,7 @@ func saveLocationToCache(loc geoclue.Location) error {
 	return err
 }
 
-func readLocationFromCache() (location *geoclue.Location) {
+func readLocationFromCache() (location *LocationInfo) {
 	cacheFilePath, err := xdg.CacheFile("darkman/location.json")
 	if err != nil {
 		log.Printf("Error determining cache file path: %v\n", err)
@@ -49,19 +63,23 @@ func readLocationFromCache() (location *geoclue.Location) {
 		return
 	}
 
-	location = &geoclue.Location{}
+	location = &LocationInfo{}
 	if err = json.Unmarshal(data, location); err != nil {
 		log.Printf("Error parsing data from cache file path: %v\n", err)
 		return nil
 	}
+	// Older versions only cached locations from geoclue, without a source.
+	if location.Source == "" {
+		location.Source = LocationFromGeoclue
+	}
 
 	return
 }
 
 // Initialise geoclue. Note that we have our own channel where we yield
 // locations, and Geoclient has its own. We act as middleman here since we also
-// keep the last location cached.
-func initGeoclue(ctx context.Context, onLocation chan (geoclue.Location)) (client *geoclue.Geoclient, err error) {
+// record when and where each location was obtained.
+func initGeoclue(ctx context.Context, onLocation chan (LocationInfo)) (client *geoclue.Geoclient, err error) {
 	client, err = geoclue.NewClient(ctx, "darkman", time.Minute, 40000, 3600*4)
 	if err != nil {
 		return nil, err
@@ -73,13 +91,11 @@ func initGeoclue(ctx context.Context, onLocation chan (geoclue.Location)) (clien
 			case <-ctx.Done():
 				return
 			case loc := <-client.Locations:
-				if err := saveLocationToCache(loc); err != nil {
-					log.Println("Error saving location to cache: ", loc)
-				} else {
-					log.Println("Saved location to cache.")
+				onLocation <- LocationInfo{
+					Location:  loc,
+					Source:    LocationFromGeoclue,
+					Timestamp: time.Now(),
 				}
-
-				onLocation <- loc
 			}
 		}
 	}()
@@ -91,7 +107,7 @@ func initGeoclue(ctx context.Context, onLocation chan (geoclue.Location)) (clien
 //
 // By default, we indicate set geoclue in a rather passive mode; it'll ignore
 // location changes that occur in less than four hours, or of less than 40km.
-func GetLocations(ctx context.Context, onLocation chan (geoclue.Location)) (err error) {
+func GetLocations(ctx context.Context, onLocation chan (LocationInfo)) (err error) {
 	if _, err = initGeoclue(ctx, onLocation); err != nil {
 		return fmt.Errorf("error initialising geoclue: %v", err)
 	}
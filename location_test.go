@@ -0,0 +1,68 @@
+package darkman
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/adrg/xdg"
+	"gitlab.com/WhyNotHugo/darkman/geoclue"
+)
+
+func TestLocationCache(t *testing.T) {
+	t.Setenv("XDG_CACHE_HOME", filepath.Join(t.TempDir(), "cache"))
+	xdg.Reload()
+	defer xdg.Reload()
+
+	if location := readLocationFromCache(); location != nil {
+		t.Errorf("want no location without a cache file, got %v", location)
+	}
+
+	want := LocationInfo{
+		Location:  geoclue.Location{Lat: 52.37, Lng: 4.89},
+		Source:    LocationFromDBus,
+		Timestamp: time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC),
+	}
+	if err := saveLocationToCache(want); err != nil {
+		t.Fatal("failed to save location:", err)
+	}
+	if got := readLocationFromCache(); got == nil || *got != want {
+		t.Errorf("want %v, got %v", want, got)
+	}
+
+	// Files written by older versions lack a source and timestamp.
+	path, err := xdg.CacheFile("darkman/location.json")
+	if err != nil {
+		t.Fatal("failed to determine cache path:", err)
+	}
+	if err := os.WriteFile(path, []byte(`{"lat":52.37,"lng":4.89,"Alt":0}`), 0600); err != nil {
+		t.Fatal("failed to write cache file:", err)
+	}
+	if got := readLocationFromCache(); got == nil || got.Lat != 52.37 || got.Source != LocationFromGeoclue {
+		t.Errorf("want a location from geoclue, got %v", got)
+	}
+}
+
+func TestSchedulerStopped(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	scheduler := NewScheduler(nil, nil, func(Mode) {})
+	if err := scheduler.Start(ctx, false); err != nil {
+		t.Fatal("failed to start scheduler:", err)
+	}
+	cancel()
+
+	// Must not block once the scheduler has stopped.
+	done := make(chan struct{})
+	go func() {
+		scheduler.SetLocation(LocationInfo{Location: geoclue.Location{Lat: 52.37, Lng: 4.89}})
+		scheduler.SetTime(nil)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Error("want SetLocation and SetTime to return after stopping")
+	}
+}
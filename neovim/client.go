@@ -0,0 +1,143 @@
+// Package neovim implements a minimal client for Neovim's msgpack-RPC API.
+//
+// See: https://neovim.io/doc/user/api.html#rpc-connecting
+package neovim
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Message types in the msgpack-RPC protocol.
+const (
+	msgRequest      = 0
+	msgResponse     = 1
+	msgNotification = 2
+)
+
+// An error returned by Neovim itself in response to a request.
+type RemoteError struct {
+	Method  string
+	Message string
+}
+
+func (err *RemoteError) Error() string {
+	return fmt.Sprintf("%v failed: %v", err.Method, err.Message)
+}
+
+// A connection to a single Neovim instance.
+type Client struct {
+	conn    net.Conn
+	reader  *bufio.Reader
+	writer  *bufio.Writer
+	timeout time.Duration
+	nextID  uint32
+}
+
+// Connect to a Neovim instance listening on a unix socket.
+//
+// Each call made with the returned client must complete within `timeout`.
+func Dial(path string, timeout time.Duration) (*Client, error) {
+	conn, err := net.DialTimeout("unix", path, timeout)
+	if err != nil {
+		return nil, err
+	}
+	return &Client{
+		conn:    conn,
+		reader:  bufio.NewReader(conn),
+		writer:  bufio.NewWriter(conn),
+		timeout: timeout,
+	}, nil
+}
+
+// Close the connection.
+func (client *Client) Close() error {
+	return client.conn.Close()
+}
+
+// Call an API method and wait for its result.
+//
+// Any requests or notifications that Neovim sends in the meantime are ignored.
+func (client *Client) Call(method string, args ...interface{}) (interface{}, error) {
+	if err := client.conn.SetDeadline(time.Now().Add(client.timeout)); err != nil {
+		return nil, err
+	}
+
+	id := client.nextID
+	client.nextID++
+
+	if args == nil {
+		args = []interface{}{}
+	}
+	request := []interface{}{msgRequest, id, method, args}
+	if err := encode(client.writer, request); err != nil {
+		return nil, fmt.Errorf("failed to encode request: %v", err)
+	}
+	if err := client.writer.Flush(); err != nil {
+		return nil, fmt.Errorf("failed to send request: %v", err)
+	}
+
+	for {
+		message, err := decode(client.reader)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read response: %v", err)
+		}
+
+		fields, ok := message.([]interface{})
+		if !ok || len(fields) == 0 {
+			return nil, fmt.Errorf("malformed message: %v", message)
+		}
+		if kind, _ := fields[0].(int64); kind != msgResponse {
+			continue
+		}
+		if len(fields) != 4 {
+			return nil, fmt.Errorf("malformed response: %v", message)
+		}
+		if responseID, _ := fields[1].(int64); responseID != int64(id) {
+			continue
+		}
+
+		if fields[2] != nil {
+			return nil, &RemoteError{Method: method, Message: errorMessage(fields[2])}
+		}
+		return fields[3], nil
+	}
+}
+
+// Neovim's errors are an array with a type and a message.
+func errorMessage(value interface{}) string {
+	if fields, ok := value.([]interface{}); ok && len(fields) == 2 {
+		if message, ok := fields[1].(string); ok {
+			return message
+		}
+	}
+	return fmt.Sprint(value)
+}
+
+// Returns the paths of the RPC sockets of running Neovim instances.
+//
+// Neovim creates these in `runtimeDir` as "nvim.<pid>.<n>". Sockets may be
+// left behind by instances which have crashed, so connecting to any of them
+// may fail.
+func Sockets(runtimeDir string) ([]string, error) {
+	entries, err := os.ReadDir(runtimeDir)
+	if err != nil {
+		return nil, err
+	}
+
+	var sockets []string
+	for _, entry := range entries {
+		if !strings.HasPrefix(entry.Name(), "nvim.") || entry.Type()&os.ModeSocket == 0 {
+			continue
+		}
+		sockets = append(sockets, filepath.Join(runtimeDir, entry.Name()))
+	}
+	sort.Strings(sockets)
+	return sockets, nil
+}
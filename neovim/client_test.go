@@ -0,0 +1,134 @@
+package neovim
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"path/filepath"
+	"reflect"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// Serve a single connection, replying to each request with `reply`. A
+// notification is sent before each response, which clients must ignore.
+func fakeServer(t *testing.T, path string, reply func(method string, args []interface{}) (interface{}, interface{})) {
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal("failed to listen:", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		r, w := bufio.NewReader(conn), bufio.NewWriter(conn)
+		for {
+			message, err := decode(r)
+			if err != nil {
+				return
+			}
+			request := message.([]interface{})
+			result, remoteErr := reply(request[2].(string), request[3].([]interface{}))
+
+			notification := []interface{}{msgNotification, "nvim_buf_lines_event", []interface{}{}}
+			response := []interface{}{msgResponse, request[1], remoteErr, result}
+			if encode(w, notification) != nil || encode(w, response) != nil || w.Flush() != nil {
+				return
+			}
+		}
+	}()
+}
+
+func TestCall(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "nvim.1234.0")
+
+	var calls [][]interface{}
+	fakeServer(t, path, func(method string, args []interface{}) (interface{}, interface{}) {
+		calls = append(calls, append([]interface{}{method}, args...))
+		if method == "nvim_command" {
+			return nil, nil
+		}
+		return nil, []interface{}{int64(0), "Vim:E492: Not an editor command"}
+	})
+
+	client, err := Dial(path, time.Second)
+	if err != nil {
+		t.Fatal("failed to connect:", err)
+	}
+	defer client.Close()
+
+	if _, err := client.Call("nvim_command", "set background=dark"); err != nil {
+		t.Errorf("call failed: %v", err)
+	}
+	_, err = client.Call("nvim_exec_lua", "return ...", []interface{}{"dark"})
+	var remoteErr *RemoteError
+	if !errors.As(err, &remoteErr) || remoteErr.Message != "Vim:E492: Not an editor command" {
+		t.Errorf("want a remote error, got %v", err)
+	}
+
+	want := [][]interface{}{
+		{"nvim_command", "set background=dark"},
+		{"nvim_exec_lua", "return ...", []interface{}{"dark"}},
+	}
+	if !reflect.DeepEqual(calls, want) {
+		t.Errorf("want calls %v, got %v", want, calls)
+	}
+}
+
+func TestSocketsAndTimeouts(t *testing.T) {
+	dir := t.TempDir()
+
+	// A socket left behind by an instance which is no longer running.
+	stale, err := net.ListenUnix("unix", &net.UnixAddr{Name: filepath.Join(dir, "nvim.1.0"), Net: "unix"})
+	if err != nil {
+		t.Fatal("failed to listen:", err)
+	}
+	stale.SetUnlinkOnClose(false)
+	stale.Close()
+
+	// An instance which never replies.
+	hung, err := net.Listen("unix", filepath.Join(dir, "nvim.2.0"))
+	if err != nil {
+		t.Fatal("failed to listen:", err)
+	}
+	defer hung.Close()
+
+	// Not a neovim socket.
+	other, err := net.Listen("unix", filepath.Join(dir, "other.0"))
+	if err != nil {
+		t.Fatal("failed to listen:", err)
+	}
+	defer other.Close()
+
+	sockets, err := Sockets(dir)
+	if err != nil {
+		t.Fatal("failed to find sockets:", err)
+	}
+	want := []string{filepath.Join(dir, "nvim.1.0"), filepath.Join(dir, "nvim.2.0")}
+	if !reflect.DeepEqual(sockets, want) {
+		t.Errorf("want sockets %v, got %v", want, sockets)
+	}
+
+	if _, err := Dial(sockets[0], time.Second); !errors.Is(err, syscall.ECONNREFUSED) {
+		t.Errorf("want connection refused for a stale socket, got %v", err)
+	}
+
+	client, err := Dial(sockets[1], 50*time.Millisecond)
+	if err != nil {
+		t.Fatal("failed to connect:", err)
+	}
+	defer client.Close()
+	start := time.Now()
+	if _, err := client.Call("nvim_command", "set background=dark"); err == nil {
+		t.Error("want an error from an instance which never replies")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("call should have timed out quickly, took %v", elapsed)
+	}
+}
@@ -0,0 +1,337 @@
+package neovim
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+)
+
+// Largest string, binary value, extension, array or map which is decoded.
+// Lengths are read from the peer, so they must be checked before allocating.
+const maxLength = 1 << 24
+
+// Space preallocated for arrays and maps. Larger ones grow as items are
+// actually read, so a bogus length cannot force a large allocation.
+const maxPrealloc = 1024
+
+// An extension value. Neovim uses these for buffer, window and tabpage handles.
+type Ext struct {
+	Type int8
+	Data []byte
+}
+
+// Encode a value as msgpack.
+//
+// Only the types needed to make requests are supported: nil, bools, integers,
+// strings, and slices and maps of these.
+func encode(w *bufio.Writer, value interface{}) error {
+	switch v := value.(type) {
+	case nil:
+		return w.WriteByte(0xc0)
+	case bool:
+		if v {
+			return w.WriteByte(0xc3)
+		}
+		return w.WriteByte(0xc2)
+	case int:
+		return encodeInt(w, int64(v))
+	case int64:
+		return encodeInt(w, v)
+	case uint32:
+		return encodeInt(w, int64(v))
+	case string:
+		return encodeString(w, v)
+	case []string:
+		if err := encodeLength(w, len(v), 0x90, 0xdd); err != nil {
+			return err
+		}
+		for _, item := range v {
+			if err := encodeString(w, item); err != nil {
+				return err
+			}
+		}
+		return nil
+	case []interface{}:
+		if err := encodeLength(w, len(v), 0x90, 0xdd); err != nil {
+			return err
+		}
+		for _, item := range v {
+			if err := encode(w, item); err != nil {
+				return err
+			}
+		}
+		return nil
+	case map[string]interface{}:
+		if err := encodeLength(w, len(v), 0x80, 0xdf); err != nil {
+			return err
+		}
+		for key, item := range v {
+			if err := encodeString(w, key); err != nil {
+				return err
+			}
+			if err := encode(w, item); err != nil {
+				return err
+			}
+		}
+		return nil
+	default:
+		return fmt.Errorf("cannot encode value of type %T", value)
+	}
+}
+
+func encodeInt(w *bufio.Writer, v int64) error {
+	var buf [9]byte
+	switch {
+	case v >= 0 && v <= 0x7f:
+		return w.WriteByte(byte(v))
+	case v < 0 && v >= -32:
+		return w.WriteByte(byte(int8(v)))
+	case v >= math.MinInt32 && v <= math.MaxInt32:
+		buf[0] = 0xd2
+		binary.BigEndian.PutUint32(buf[1:], uint32(int32(v)))
+		_, err := w.Write(buf[:5])
+		return err
+	default:
+		buf[0] = 0xd3
+		binary.BigEndian.PutUint64(buf[1:], uint64(v))
+		_, err := w.Write(buf[:9])
+		return err
+	}
+}
+
+// Write the header for a string, array or map. `fix` is the header for short
+// values, and `long` is the header with a 32 bit length. Strings use `fix` for
+// up to 31 bytes, arrays and maps for up to 15 items.
+func encodeLength(w *bufio.Writer, n int, fix byte, long byte) error {
+	max := 15
+	if fix == 0xa0 {
+		max = 31
+	}
+	if n <= max {
+		return w.WriteByte(fix | byte(n))
+	}
+	var buf [5]byte
+	buf[0] = long
+	binary.BigEndian.PutUint32(buf[1:], uint32(n))
+	_, err := w.Write(buf[:])
+	return err
+}
+
+func encodeString(w *bufio.Writer, s string) error {
+	if err := encodeLength(w, len(s), 0xa0, 0xdb); err != nil {
+		return err
+	}
+	_, err := w.WriteString(s)
+	return err
+}
+
+// Returns an error if a length read from the peer is too large.
+func checkLength(n uint64) error {
+	if n > maxLength {
+		return fmt.Errorf("msgpack value too large (%d)", n)
+	}
+	return nil
+}
+
+// Decode a single msgpack value.
+//
+// Integers are returned as int64 (or uint64 if they do not fit), strings as
+// string, binary data as []byte, arrays as []interface{}, maps as
+// map[interface{}]interface{} and extensions as Ext.
+func decode(r *bufio.Reader) (interface{}, error) {
+	b, err := r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case b <= 0x7f:
+		return int64(b), nil
+	case b >= 0xe0:
+		return int64(int8(b)), nil
+	case b&0xe0 == 0xa0:
+		return decodeString(r, int(b&0x1f))
+	case b&0xf0 == 0x90:
+		return decodeArray(r, int(b&0x0f))
+	case b&0xf0 == 0x80:
+		return decodeMap(r, int(b&0x0f))
+	}
+
+	switch b {
+	case 0xc0:
+		return nil, nil
+	case 0xc2:
+		return false, nil
+	case 0xc3:
+		return true, nil
+	case 0xc4, 0xc5, 0xc6:
+		n, err := readUint(r, 1<<(b-0xc4))
+		if err == nil {
+			err = checkLength(n)
+		}
+		if err != nil {
+			return nil, err
+		}
+		return readBytes(r, int(n))
+	case 0xc7, 0xc8, 0xc9:
+		n, err := readUint(r, 1<<(b-0xc7))
+		if err == nil {
+			err = checkLength(n)
+		}
+		if err != nil {
+			return nil, err
+		}
+		return decodeExt(r, int(n))
+	case 0xca:
+		n, err := readUint(r, 4)
+		return float64(math.Float32frombits(uint32(n))), err
+	case 0xcb:
+		n, err := readUint(r, 8)
+		return math.Float64frombits(n), err
+	case 0xcc, 0xcd, 0xce, 0xcf:
+		n, err := readUint(r, 1<<(b-0xcc))
+		if err != nil {
+			return nil, err
+		}
+		if n > math.MaxInt64 {
+			return n, nil
+		}
+		return int64(n), nil
+	case 0xd0:
+		n, err := readUint(r, 1)
+		return int64(int8(n)), err
+	case 0xd1:
+		n, err := readUint(r, 2)
+		return int64(int16(n)), err
+	case 0xd2:
+		n, err := readUint(r, 4)
+		return int64(int32(n)), err
+	case 0xd3:
+		n, err := readUint(r, 8)
+		return int64(n), err
+	case 0xd4, 0xd5, 0xd6, 0xd7, 0xd8:
+		return decodeExt(r, 1<<(b-0xd4))
+	case 0xd9, 0xda, 0xdb:
+		n, err := readUint(r, 1<<(b-0xd9))
+		if err == nil {
+			err = checkLength(n)
+		}
+		if err != nil {
+			return nil, err
+		}
+		return decodeString(r, int(n))
+	case 0xdc, 0xdd:
+		n, err := readUint(r, 2<<(b-0xdc))
+		if err == nil {
+			err = checkLength(n)
+		}
+		if err != nil {
+			return nil, err
+		}
+		return decodeArray(r, int(n))
+	case 0xde, 0xdf:
+		n, err := readUint(r, 2<<(b-0xde))
+		if err == nil {
+			err = checkLength(n)
+		}
+		if err != nil {
+			return nil, err
+		}
+		return decodeMap(r, int(n))
+	}
+
+	return nil, fmt.Errorf("invalid msgpack type 0x%x", b)
+}
+
+// Read a big endian unsigned integer of `size` bytes.
+func readUint(r *bufio.Reader, size int) (uint64, error) {
+	buf, err := readBytes(r, size)
+	if err != nil {
+		return 0, err
+	}
+	var n uint64
+	for _, b := range buf {
+		n = n<<8 | uint64(b)
+	}
+	return n, nil
+}
+
+// Read exactly `n` bytes. The buffer grows as data is actually read, so that
+// a bogus length cannot force a large allocation.
+func readBytes(r *bufio.Reader, n int) ([]byte, error) {
+	if n <= maxPrealloc {
+		buf := make([]byte, n)
+		_, err := io.ReadFull(r, buf)
+		return buf, err
+	}
+	buf, err := io.ReadAll(io.LimitReader(r, int64(n)))
+	if err == nil && len(buf) < n {
+		err = io.ErrUnexpectedEOF
+	}
+	return buf, err
+}
+
+func decodeString(r *bufio.Reader, n int) (interface{}, error) {
+	buf, err := readBytes(r, n)
+	return string(buf), err
+}
+
+func decodeExt(r *bufio.Reader, n int) (interface{}, error) {
+	t, err := r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	data, err := readBytes(r, n)
+	return Ext{Type: int8(t), Data: data}, err
+}
+
+// Returns how many items to preallocate for an array or map of `n` items.
+func prealloc(n int) int {
+	if n > maxPrealloc {
+		return maxPrealloc
+	}
+	return n
+}
+
+func decodeArray(r *bufio.Reader, n int) (interface{}, error) {
+	array := make([]interface{}, 0, prealloc(n))
+	for i := 0; i < n; i++ {
+		item, err := decode(r)
+		if err != nil {
+			return nil, err
+		}
+		array = append(array, item)
+	}
+	return array, nil
+}
+
+func decodeMap(r *bufio.Reader, n int) (interface{}, error) {
+	m := make(map[interface{}]interface{}, prealloc(n))
+	for i := 0; i < n; i++ {
+		key, err := decode(r)
+		if err != nil {
+			return nil, err
+		}
+		value, err := decode(r)
+		if err != nil {
+			return nil, err
+		}
+		if !isHashable(key) {
+			return nil, fmt.Errorf("unsupported map key of type %T", key)
+		}
+		m[key] = value
+	}
+	return m, nil
+}
+
+// Maps with arrays, maps or extensions as keys cannot be represented.
+func isHashable(key interface{}) bool {
+	switch key.(type) {
+	case []interface{}, map[interface{}]interface{}, []byte, Ext:
+		return false
+	default:
+		return true
+	}
+}
@@ -0,0 +1,34 @@
+package neovim
+
+import (
+	"bufio"
+	"bytes"
+	"testing"
+)
+
+func TestDecodeLengths(t *testing.T) {
+	cases := []struct {
+		name  string
+		input []byte
+		ok    bool
+	}{
+		{"short string", []byte{0xa3, 'a', 'b', 'c'}, true},
+		{"long string", append([]byte{0xda, 0x08, 0x00}, bytes.Repeat([]byte{'x'}, 2048)...), true},
+		{"huge string", []byte{0xdb, 0xff, 0xff, 0xff, 0xff}, false},
+		{"huge binary", []byte{0xc6, 0xff, 0xff, 0xff, 0xff}, false},
+		{"huge extension", []byte{0xc9, 0xff, 0xff, 0xff, 0xff, 0x01}, false},
+		{"huge array", []byte{0xdd, 0xff, 0xff, 0xff, 0xff}, false},
+		{"huge map", []byte{0xdf, 0xff, 0xff, 0xff, 0xff}, false},
+		{"truncated string", []byte{0xdb, 0x00, 0x10, 0x00, 0x00, 'a', 'b', 'c'}, false},
+		{"truncated array", []byte{0xdd, 0x00, 0x10, 0x00, 0x00, 0x01, 0x02}, false},
+	}
+
+	for _, c := range cases {
+		_, err := decode(bufio.NewReader(bytes.NewReader(c.input)))
+		if c.ok && err != nil {
+			t.Errorf("%v: failed to decode: %v", c.name, err)
+		} else if !c.ok && err == nil {
+			t.Errorf("%v: want an error", c.name)
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package darkman
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"syscall"
+
+	"github.com/adrg/xdg"
+	"gitlab.com/WhyNotHugo/darkman/neovim"
+)
+
+// Updates all running Neovim instances on each transition.
+type NeovimNotifier struct {
+	config     NeovimConfig
+	runtimeDir string
+}
+
+// Creates a new NeovimNotifier using the settings in `config`.
+func NewNeovimNotifier(config *Config) *NeovimNotifier {
+	return &NeovimNotifier{
+		config:     config.Neovim,
+		runtimeDir: xdg.RuntimeDir,
+	}
+}
+
+// Apply `mode` to a single Neovim instance.
+func (notifier *NeovimNotifier) notify(socket string, mode Mode) error {
+	client, err := neovim.Dial(socket, notifier.config.Timeout)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	if notifier.config.Lua != "" {
+		_, err = client.Call("nvim_exec_lua", notifier.config.Lua, []interface{}{string(mode)})
+	} else {
+		_, err = client.Call("nvim_command", fmt.Sprintf("set background=%v", mode))
+	}
+	return err
+}
+
+// Set the background (or run the configured Lua code) in all running Neovim
+// instances.
+//
+// Instances are updated concurrently, so an unresponsive one does not delay
+// others. Sockets left behind by instances which are no longer running are
+// ignored.
+func (notifier *NeovimNotifier) ChangeMode(mode Mode) error {
+	if !notifier.config.Enabled || mode == NULL {
+		return nil
+	}
+
+	sockets, err := neovim.Sockets(notifier.runtimeDir)
+	if err != nil {
+		return fmt.Errorf("failed to find neovim instances: %v", err)
+	}
+
+	var failures []string
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, socket := range sockets {
+		wg.Add(1)
+		go func(socket string) {
+			defer wg.Done()
+			err := notifier.notify(socket, mode)
+			if errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, syscall.ENOENT) {
+				log.Printf("Ignoring stale neovim socket %v.\n", socket)
+				return
+			} else if err != nil {
+				mu.Lock()
+				failures = append(failures, fmt.Sprintf("%v: %v", socket, err))
+				mu.Unlock()
+				return
+			}
+			log.Printf("Applied %v mode to neovim at %v.\n", mode, socket)
+		}(socket)
+	}
+	wg.Wait()
+
+	if len(failures) > 0 {
+		return fmt.Errorf("failed to update neovim:\n  %v", strings.Join(failures, "\n  "))
+	}
+	return nil
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"log"
+	"sync"
 
 	"github.com/godbus/dbus/v5"
 	"github.com/godbus/dbus/v5/introspect"
@@ -23,8 +24,11 @@ const PORTAL_OBJ_PATH = "/org/freedesktop/portal/desktop"
 const PORTAL_INTERFACE = "org.freedesktop.impl.portal.Settings"
 
 type PortalHandle struct {
+	bus *busName
+	// The current connection, which changes if it is lost.
 	conn *dbus.Conn
 	mode uint
+	mu   sync.Mutex
 }
 
 func modeToPortalValue(mode Mode) uint {
@@ -43,17 +47,20 @@ func modeToPortalValue(mode Mode) uint {
 }
 
 func (portal *PortalHandle) ChangeMode(newMode Mode) error {
-	if portal.conn == nil {
+	portal.mu.Lock()
+	portal.mode = modeToPortalValue(newMode)
+	conn, value := portal.conn, portal.mode
+	portal.mu.Unlock()
+	if conn == nil || !conn.Connected() {
 		return fmt.Errorf("cannot emit portal signal; no connection to dbus")
 	}
 
-	portal.mode = modeToPortalValue(newMode)
-	if err := portal.conn.Emit(
+	if err := conn.Emit(
 		PORTAL_OBJ_PATH,
 		PORTAL_INTERFACE+".SettingChanged",
 		PORTAL_COLOR_SCHEME_NAMESPACE,
 		PORTAL_COLOR_SCHEME_KEY,
-		dbus.MakeVariant(portal.mode),
+		dbus.MakeVariant(value),
 	); err != nil {
 		log.Printf("couldn't emit signal: %v", err)
 	}
@@ -61,27 +68,33 @@ func (portal *PortalHandle) ChangeMode(newMode Mode) error {
 	return nil
 }
 
-// Create a new D-Bus server instance for the XDG portal API.
+// Create a new D-Bus server instance for the XDG portal API. With `replace`,
+// the portal's bus name is taken over from another instance.
 //
 // ChangeMode must be called on the returned handle each time that the current
 // mode changes by some other mechanism.
-func NewPortal(ctx context.Context, initial Mode) (*PortalHandle, error) {
+func NewPortal(ctx context.Context, initial Mode, replace bool) (*PortalHandle, error) {
 	portal := PortalHandle{mode: modeToPortalValue(initial)}
 
-	if err := portal.start(ctx); err != nil {
+	if err := portal.start(ctx, replace); err != nil {
 		return nil, fmt.Errorf("could not start D-Bus server: %v", err)
 	}
 
 	return &portal, nil
 }
 
-func (portal *PortalHandle) start(ctx context.Context) (err error) {
-	opts := dbus.WithContext(ctx)
-	portal.conn, err = dbus.ConnectSessionBus(opts)
-	if err != nil {
-		return fmt.Errorf("could not connect to session D-Bus: %v", err)
-	}
+func (portal *PortalHandle) start(ctx context.Context, replace bool) error {
+	portal.bus = newBusName(PORTAL_BUS_NAME, replace, portal.export)
+	return portal.bus.Start(ctx)
+}
+
+// Closed when another instance takes over the portal's bus name.
+func (portal *PortalHandle) Replaced() <-chan struct{} {
+	return portal.bus.Replaced()
+}
 
+// Export all objects on a new connection.
+func (portal *PortalHandle) export(conn *dbus.Conn) error {
 	// Define the "Version" prop (its value will be static).
 	propsSpec := map[string]map[string]*prop.Prop{
 		PORTAL_INTERFACE: {
@@ -93,14 +106,14 @@ func (portal *PortalHandle) start(ctx context.Context) (err error) {
 		},
 	}
 	// Export the "Version" prop.
-	versionProp, err := prop.Export(portal.conn, PORTAL_OBJ_PATH, propsSpec)
+	versionProp, err := prop.Export(conn, PORTAL_OBJ_PATH, propsSpec)
 	if err != nil {
 		return fmt.Errorf("failed to export D-Bus prop: %v", err)
 	}
 
 	// Exoprt the D-Bus object.
 
-	if err = portal.conn.Export(portal, PORTAL_OBJ_PATH, PORTAL_INTERFACE); err != nil {
+	if err = conn.Export(portal, PORTAL_OBJ_PATH, PORTAL_INTERFACE); err != nil {
 		return fmt.Errorf("failed to export interface: %v", err)
 	}
 
@@ -176,7 +189,7 @@ func (portal *PortalHandle) start(ctx context.Context) (err error) {
 		},
 	}
 
-	if err = portal.conn.Export(
+	if err = conn.Export(
 		introspect.NewIntrospectable(n),
 		PORTAL_OBJ_PATH,
 		"org.freedesktop.DBus.Introspectable",
@@ -184,22 +197,23 @@ func (portal *PortalHandle) start(ctx context.Context) (err error) {
 		return fmt.Errorf("failed to export dbus name: %v", err)
 	}
 
-	reply, err := portal.conn.RequestName(PORTAL_BUS_NAME, dbus.NameFlagDoNotQueue)
-	if err != nil {
-		return fmt.Errorf("failed to register dbus name: %v", err)
-	}
-	if reply != dbus.RequestNameReplyPrimaryOwner {
-		return fmt.Errorf("can't register D-Bus name: name already taken")
-	}
-
-	log.Println("Listening on D-Bus:", PORTAL_BUS_NAME)
+	portal.mu.Lock()
+	portal.conn = conn
+	portal.mu.Unlock()
 
 	return nil
 }
 
+// Returns the current value of the color-scheme setting.
+func (portal *PortalHandle) colorScheme() dbus.Variant {
+	portal.mu.Lock()
+	defer portal.mu.Unlock()
+	return dbus.MakeVariant(portal.mode)
+}
+
 func (portal *PortalHandle) Read(namespace string, key string) (dbus.Variant, *dbus.Error) {
 	if namespace == PORTAL_COLOR_SCHEME_NAMESPACE && key == PORTAL_COLOR_SCHEME_KEY {
-		return dbus.MakeVariant(portal.mode), nil
+		return portal.colorScheme(), nil
 	}
 	if namespace == PORTAL_DARKMAN_NAMESPACE && key == PORTAL_DARKMAN_STATUS_KEY {
 		return dbus.MakeVariant("running"), nil
@@ -215,7 +229,7 @@ func (portal *PortalHandle) ReadAll(namespaces []string) (map[string]map[string]
 	for _, namespace := range namespaces {
 		if namespace == PORTAL_COLOR_SCHEME_NAMESPACE {
 			values[PORTAL_COLOR_SCHEME_NAMESPACE] = map[string]dbus.Variant{
-				PORTAL_COLOR_SCHEME_KEY: dbus.MakeVariant(portal.mode),
+				PORTAL_COLOR_SCHEME_KEY: portal.colorScheme(),
 			}
 		}
 		if namespace == PORTAL_DARKMAN_NAMESPACE {
@@ -0,0 +1,136 @@
+package darkman
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+
+	"github.com/godbus/dbus/v5"
+)
+
+// The portal frontend, which merges the settings of all backends.
+const PORTAL_FRONTEND_BUS_NAME = "org.freedesktop.portal.Desktop"
+const PORTAL_FRONTEND_INTERFACE = "org.freedesktop.portal.Settings"
+
+// Returns the mode for a value of the portal's color-scheme setting. Both "no
+// preference" and "prefer light" mean light mode.
+func portalValueToMode(value dbus.Variant) (Mode, error) {
+	// The frontend's deprecated Read method wraps values in a second variant.
+	for {
+		inner, ok := value.Value().(dbus.Variant)
+		if !ok {
+			break
+		}
+		value = inner
+	}
+
+	scheme, ok := value.Value().(uint32)
+	if !ok {
+		return NULL, fmt.Errorf("unexpected color-scheme value: %v", value)
+	}
+	if scheme == 1 {
+		return DARK, nil
+	}
+	return LIGHT, nil
+}
+
+// Follows the color-scheme setting of another settings portal, such as the one
+// implemented by the desktop environment.
+//
+// The source may be the bus name of another portal backend (which implements
+// org.freedesktop.impl.portal.Settings), or the portal frontend itself.
+type PortalMirror struct {
+	source string
+	// Called with the mode for each change to the setting.
+	override func(Mode)
+	// darkman's current mode.
+	current Mode
+	mu      sync.Mutex
+}
+
+// Creates a new PortalMirror which follows the portal at the bus name `source`.
+func NewPortalMirror(source string, override func(Mode)) *PortalMirror {
+	return &PortalMirror{
+		source:   source,
+		override: override,
+		current:  NULL,
+	}
+}
+
+// Returns the interface implemented by the source.
+func (mirror *PortalMirror) iface() string {
+	if mirror.source == PORTAL_FRONTEND_BUS_NAME {
+		return PORTAL_FRONTEND_INTERFACE
+	}
+	return PORTAL_INTERFACE
+}
+
+// Apply a color-scheme value from the source.
+func (mirror *PortalMirror) apply(value dbus.Variant) {
+	mode, err := portalValueToMode(value)
+	if err != nil {
+		log.Printf("Could not follow %v: %v\n", mirror.source, err)
+		return
+	}
+
+	mirror.mu.Lock()
+	current := mirror.current
+	mirror.mu.Unlock()
+	if mode == current {
+		return
+	}
+
+	log.Printf("%v changed to %v mode.\n", mirror.source, mode)
+	mirror.override(mode)
+}
+
+// Start following changes to the setting, until `ctx` is cancelled. The
+// source's current value is applied immediately. If the connection to the
+// session bus is lost, it reconnects and applies the current value again.
+func (mirror *PortalMirror) Start(ctx context.Context) error {
+	subscribe := func(conn *dbus.Conn) error {
+		// Matching on the well-known name also follows the source if it
+		// restarts.
+		if err := conn.AddMatchSignal(
+			dbus.WithMatchSender(mirror.source),
+			dbus.WithMatchObjectPath(PORTAL_OBJ_PATH),
+			dbus.WithMatchInterface(mirror.iface()),
+			dbus.WithMatchMember("SettingChanged"),
+		); err != nil {
+			return fmt.Errorf("error listening for signal: %v", err)
+		}
+
+		var value dbus.Variant
+		obj := conn.Object(mirror.source, PORTAL_OBJ_PATH)
+		err := obj.Call(mirror.iface()+".Read", 0, PORTAL_COLOR_SCHEME_NAMESPACE, PORTAL_COLOR_SCHEME_KEY).Store(&value)
+		if err != nil {
+			// The source may start later, in which case its changes still
+			// apply.
+			log.Printf("Could not read color-scheme from %v: %v\n", mirror.source, err)
+		} else {
+			mirror.apply(value)
+		}
+		return nil
+	}
+
+	return followSignals(ctx, mirror.source, subscribe, func(signal *dbus.Signal) {
+		var namespace, key string
+		var value dbus.Variant
+		if err := dbus.Store(signal.Body, &namespace, &key, &value); err != nil {
+			log.Printf("Malformed signal from %v: %v\n", mirror.source, err)
+			return
+		}
+		if namespace == PORTAL_COLOR_SCHEME_NAMESPACE && key == PORTAL_COLOR_SCHEME_KEY {
+			mirror.apply(value)
+		}
+	})
+}
+
+// Keep track of darkman's current mode.
+func (mirror *PortalMirror) ChangeMode(mode Mode) error {
+	mirror.mu.Lock()
+	defer mirror.mu.Unlock()
+	mirror.current = mode
+	return nil
+}
@@ -0,0 +1,92 @@
+package darkman
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/godbus/dbus/v5"
+)
+
+func TestPortalValueToMode(t *testing.T) {
+	cases := map[interface{}]Mode{
+		uint32(0): LIGHT,
+		uint32(1): DARK,
+		uint32(2): LIGHT,
+	}
+	for value, want := range cases {
+		if got, err := portalValueToMode(dbus.MakeVariant(value)); err != nil || got != want {
+			t.Errorf("%v: want %v, got %v (%v)", value, want, got, err)
+		}
+	}
+
+	nested := dbus.MakeVariant(dbus.MakeVariant(uint32(1)))
+	if got, err := portalValueToMode(nested); err != nil || got != DARK {
+		t.Errorf("want dark mode for a nested variant, got %v (%v)", got, err)
+	}
+	if _, err := portalValueToMode(dbus.MakeVariant("dark")); err == nil {
+		t.Error("want an error for a string")
+	}
+}
+
+// A stand-in for another portal backend.
+type fakePortal struct{}
+
+func (portal *fakePortal) Read(namespace string, key string) (dbus.Variant, *dbus.Error) {
+	return dbus.MakeVariant(uint32(1)), nil
+}
+
+func TestPortalMirror(t *testing.T) {
+	address := startSessionBus(t)
+	const source = "org.freedesktop.impl.portal.desktop.test"
+
+	conn, err := dbus.Connect(address)
+	if err != nil {
+		t.Fatal("failed to connect to bus:", err)
+	}
+	defer conn.Close()
+	if err := conn.Export(&fakePortal{}, PORTAL_OBJ_PATH, PORTAL_INTERFACE); err != nil {
+		t.Fatal("failed to export portal:", err)
+	}
+	if _, err := conn.RequestName(source, dbus.NameFlagDoNotQueue); err != nil {
+		t.Fatal("failed to request name:", err)
+	}
+
+	other, err := dbus.Connect(address)
+	if err != nil {
+		t.Fatal("failed to connect to bus:", err)
+	}
+	defer other.Close()
+
+	overrides := make(chan Mode, 10)
+	mirror := NewPortalMirror(source, func(mode Mode) { overrides <- mode })
+	mirror.ChangeMode(LIGHT)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if err := mirror.Start(ctx); err != nil {
+		t.Fatal("failed to start:", err)
+	}
+	// The initial value is applied right away.
+	expectOverride(t, overrides, DARK)
+	mirror.ChangeMode(DARK)
+
+	// Signals from anyone other than the source are ignored.
+	emit := func(conn *dbus.Conn, namespace string, value uint32) {
+		t.Helper()
+		err := conn.Emit(PORTAL_OBJ_PATH, PORTAL_INTERFACE+".SettingChanged", namespace, PORTAL_COLOR_SCHEME_KEY, dbus.MakeVariant(value))
+		if err != nil {
+			t.Fatal("failed to emit signal:", err)
+		}
+	}
+	emit(other, PORTAL_COLOR_SCHEME_NAMESPACE, 2)
+	emit(conn, "org.example", 2)
+	emit(conn, PORTAL_COLOR_SCHEME_NAMESPACE, 0)
+	expectOverride(t, overrides, LIGHT)
+
+	select {
+	case mode := <-overrides:
+		t.Errorf("want a single change applied, got %v", mode)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
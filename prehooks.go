@@ -0,0 +1,142 @@
+package darkman
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"log"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Pre-transition hooks are killed if they take longer than this, so that they
+// cannot block transitions indefinitely.
+const preHookTimeout = 10 * time.Second
+
+// Postponement used when a hook does not specify one.
+const defaultPostpone = 10 * time.Minute
+
+// Exit codes with which pre-transition hooks veto or postpone a transition.
+const (
+	exitVeto     = 100
+	exitPostpone = 101
+)
+
+// Outcome of running pre-transition hooks.
+type Verdict struct {
+	// The transition must not happen.
+	Veto bool
+	// The transition must be attempted again after this delay.
+	Postpone time.Duration
+}
+
+// Runs executables in pre-dark.d and pre-light.d before automatic transitions.
+type PreTransitionHooks struct {
+	scriptDirs []string
+	vars       map[Mode]map[string]string
+}
+
+// Creates a new PreTransitionHooks, using the additional script directories
+// and variables from `config`.
+func NewPreTransitionHooks(config *Config) *PreTransitionHooks {
+	return &PreTransitionHooks{
+		scriptDirs: config.ScriptDirs,
+		vars:       config.Vars,
+	}
+}
+
+// Returns the directories which may contain pre-transition hooks for a given
+// mode, in increasing order of precedence.
+func PreHookDirectories(mode Mode, extraDirs []string) []string {
+	return searchDirectories(fmt.Sprintf("pre-%v.d", mode), extraDirs)
+}
+
+// Parse an amount of minutes from the first field, if any.
+func parseMinutes(fields []string, fallback time.Duration) time.Duration {
+	if len(fields) > 0 {
+		if minutes, err := strconv.Atoi(fields[0]); err == nil && minutes > 0 {
+			return time.Duration(minutes) * time.Minute
+		}
+	}
+	return fallback
+}
+
+// Determine the verdict of a single hook based on its exit status and output.
+//
+// A hook vetoes a transition by exiting with status 100 or printing "veto".
+// It postpones a transition by exiting with status 101 or printing "postpone",
+// optionally followed by an amount of minutes (e.g.: "postpone 15"). Any other
+// failure is logged, but does not prevent the transition.
+func parseVerdict(name string, err error, output []byte) Verdict {
+	var exitErr *exec.ExitError
+	exitCode := 0
+	if errors.As(err, &exitErr) {
+		exitCode = exitErr.ExitCode()
+	} else if err != nil {
+		log.Printf("Pre-transition hook %v failed, ignoring it: %v\n", name, err)
+		return Verdict{}
+	}
+
+	var verdict Verdict
+	switch exitCode {
+	case 0:
+	case exitVeto:
+		verdict.Veto = true
+	case exitPostpone:
+		verdict.Postpone = defaultPostpone
+	default:
+		log.Printf("Pre-transition hook %v failed, ignoring it: %v\n", name, err)
+		return Verdict{}
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(output))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		switch fields[0] {
+		case "veto":
+			verdict.Veto = true
+		case "postpone":
+			verdict.Postpone = parseMinutes(fields[1:], defaultPostpone)
+		default:
+			// With exit status 101, the output may be just the minutes.
+			if exitCode == exitPostpone {
+				verdict.Postpone = parseMinutes(fields, verdict.Postpone)
+			}
+		}
+	}
+
+	return verdict
+}
+
+// Run all pre-transition hooks for a transition into `mode`.
+//
+// If any hook vetoes the transition, no further hooks run. If several hooks
+// postpone it, the longest delay is used.
+func (hooks *PreTransitionHooks) Approve(mode Mode) Verdict {
+	var verdict Verdict
+
+	for _, script := range enabledOnly(findScripts(PreHookDirectories(mode, hooks.scriptDirs))) {
+		script.Timeout = preHookTimeout
+
+		var output bytes.Buffer
+		err := runScriptCapturing(mode, script, ScriptEnvironment(hooks.vars[mode], mode), preHookLogDir, &output)
+
+		result := parseVerdict(script.Name, err, output.Bytes())
+		if result.Veto {
+			log.Printf("Transition to %v mode vetoed by %v.\n", mode, script.Path)
+			return result
+		}
+		if result.Postpone > verdict.Postpone {
+			log.Printf("Transition to %v mode postponed %v by %v.\n", mode, result.Postpone, script.Path)
+			verdict.Postpone = result.Postpone
+		}
+	}
+
+	return verdict
+}
@@ -0,0 +1,35 @@
+package darkman
+
+import (
+	"os/exec"
+	"testing"
+	"time"
+)
+
+func TestParseVerdict(t *testing.T) {
+	exitWith := func(code string) error {
+		return exec.Command("sh", "-c", "exit "+code).Run()
+	}
+
+	cases := []struct {
+		name   string
+		err    error
+		output string
+		want   Verdict
+	}{
+		{"approve", nil, "", Verdict{}},
+		{"veto by output", nil, "some text\nveto\n", Verdict{Veto: true}},
+		{"veto by exit code", exitWith("100"), "", Verdict{Veto: true}},
+		{"postpone by output", nil, "postpone 15\n", Verdict{Postpone: 15 * time.Minute}},
+		{"postpone default", nil, "postpone\n", Verdict{Postpone: defaultPostpone}},
+		{"postpone by exit code", exitWith("101"), "", Verdict{Postpone: defaultPostpone}},
+		{"postpone by exit code with minutes", exitWith("101"), "5\n", Verdict{Postpone: 5 * time.Minute}},
+		{"other failures are ignored", exitWith("1"), "veto\n", Verdict{}},
+	}
+
+	for _, c := range cases {
+		if got := parseVerdict(c.name, c.err, []byte(c.output)); got != c.want {
+			t.Errorf("%v: want %+v, got %+v", c.name, c.want, got)
+		}
+	}
+}
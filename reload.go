@@ -0,0 +1,548 @@
+package darkman
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rxwycdh/rxhash"
+)
+
+// A part of the service which is set up from a subset of the configuration,
+// and set up again whenever that subset changes.
+type component struct {
+	name string
+	// Returns the subset of the configuration used by this component. Must
+	// return a struct, which is hashed to detect changes.
+	settings func(config *Config) interface{}
+	// Set up the component with `config`. Anything tied to `ctx` is released
+	// when the component is set up again. Returns a listener for mode changes,
+	// which may be nil if the component is disabled.
+	setup func(ctx context.Context, config *Config) (func(Mode) error, error)
+	// How long to keep the previous setup around after replacing it.
+	linger time.Duration
+
+	hash     string
+	cancel   context.CancelFunc
+	listener func(Mode) error
+	// Error from the last attempt to set up the component, if it failed.
+	err error
+	mu  sync.Mutex
+}
+
+// Pass a mode change on to the component's current listener, if any.
+func (c *component) ChangeMode(mode Mode) error {
+	c.mu.Lock()
+	listener := c.listener
+	c.mu.Unlock()
+	if listener == nil {
+		return nil
+	}
+	return listener(mode)
+}
+
+// Returns true if the component failed to be set up the last time.
+func (c *component) failed() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.err != nil
+}
+
+// Set up the component again if its settings have changed since the last
+// time. Returns whether it was set up again.
+func (c *component) configure(ctx context.Context, config *Config) (bool, error) {
+	hash, err := rxhash.HashStruct(c.settings(config))
+	if err != nil {
+		return false, fmt.Errorf("%v: failed to hash settings: %v", c.name, err)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if hash == c.hash {
+		return false, nil
+	}
+
+	if c.cancel != nil {
+		if c.linger > 0 {
+			time.AfterFunc(c.linger, c.cancel)
+		} else {
+			c.cancel()
+		}
+	}
+
+	childCtx, cancel := context.WithCancel(ctx)
+	listener, err := c.setup(childCtx, config)
+	if err != nil {
+		cancel()
+		// Try again on the next reload, even if nothing changes.
+		c.hash, c.cancel, c.listener = "", nil, nil
+		c.err = fmt.Errorf("%v: %v", c.name, err)
+		return true, c.err
+	}
+	c.hash, c.cancel, c.listener, c.err = hash, cancel, listener, nil
+	return true, nil
+}
+
+// The running service, along with everything that is set up from the
+// configuration, and may be set up again when the configuration is reloaded.
+type Daemon struct {
+	ctx        context.Context
+	version    string
+	replace    bool
+	config     Config
+	service    *Service
+	scheduler  *Scheduler
+	results    *Results
+	components []*component
+	// The current script runner, and D-Bus server if it is enabled.
+	runner     *ScriptRunner
+	dbusServer *DBusHandle
+	mu         sync.Mutex
+	// Held for the duration of each reload.
+	reloadMu sync.Mutex
+	// Closed when another instance takes over.
+	replaced     chan struct{}
+	replacedOnce sync.Once
+}
+
+// Creates a new Daemon, which applies `config` to `service` and `scheduler`.
+// The version is only shown to D-Bus clients. With `replace`, D-Bus names are
+// taken over from another running instance. Nothing is set up until Start is
+// called.
+func NewDaemon(ctx context.Context, version string, replace bool, config Config, service *Service, scheduler *Scheduler, results *Results) *Daemon {
+	daemon := &Daemon{
+		ctx:       ctx,
+		version:   version,
+		replace:   replace,
+		replaced:  make(chan struct{}),
+		config:    config,
+		service:   service,
+		scheduler: scheduler,
+		results:   results,
+	}
+	daemon.components = daemon.newComponents()
+	scheduler.AddLocationListener(daemon.changeLocation)
+	return daemon
+}
+
+// Returns all components, in the order in which they are notified of changes.
+func (daemon *Daemon) newComponents() []*component {
+	service, scheduler, results := daemon.service, daemon.scheduler, daemon.results
+
+	return []*component{
+		{
+			name: "scripts",
+			settings: func(config *Config) interface{} {
+				return struct {
+					Retry      RetryConfig
+					Hooks      map[Mode][]Hook
+					ScriptDirs []string
+					Vars       map[Mode]map[string]string
+				}{config.Retry, config.Hooks, config.ScriptDirs, config.Vars}
+			},
+			setup: func(ctx context.Context, config *Config) (func(Mode) error, error) {
+				runner := NewScriptRunner(config, results)
+				if err := runner.Watch(ctx); err != nil {
+					log.Println("Could not watch script directories:", err)
+				}
+				go func() {
+					<-ctx.Done()
+					runner.Stop()
+				}()
+				service.SetApprover(NewPreTransitionHooks(config).Approve)
+
+				daemon.mu.Lock()
+				daemon.runner = runner
+				daemon.mu.Unlock()
+				return runner.RunScripts, nil
+			},
+		},
+		{
+			name: "links",
+			settings: func(config *Config) interface{} {
+				return struct{ Links []Link }{config.Links}
+			},
+			setup: func(ctx context.Context, config *Config) (func(Mode) error, error) {
+				return NewLinkSwitcher(config).ChangeMode, nil
+			},
+		},
+		{
+			name: "neovim",
+			settings: func(config *Config) interface{} {
+				return struct{ Neovim NeovimConfig }{config.Neovim}
+			},
+			setup: func(ctx context.Context, config *Config) (func(Mode) error, error) {
+				return NewNeovimNotifier(config).ChangeMode, nil
+			},
+		},
+		{
+			name: "signals",
+			settings: func(config *Config) interface{} {
+				return struct{ Signals []Signal }{config.Signals}
+			},
+			setup: func(ctx context.Context, config *Config) (func(Mode) error, error) {
+				return NewProcessSignaller(config).ChangeMode, nil
+			},
+		},
+		{
+			name: "gsettings",
+			settings: func(config *Config) interface{} {
+				return struct{ GSettings []GSetting }{config.GSettings}
+			},
+			setup: func(ctx context.Context, config *Config) (func(Mode) error, error) {
+				return NewGSettingsWriter(ctx, config).ChangeMode, nil
+			},
+		},
+		{
+			name: "ini",
+			settings: func(config *Config) interface{} {
+				return struct{ Ini []IniFile }{config.Ini}
+			},
+			setup: func(ctx context.Context, config *Config) (func(Mode) error, error) {
+				return NewIniWriter(ctx, config).ChangeMode, nil
+			},
+		},
+		{
+			name: "kitty",
+			settings: func(config *Config) interface{} {
+				return struct{ Kitty KittyConfig }{config.Kitty}
+			},
+			setup: func(ctx context.Context, config *Config) (func(Mode) error, error) {
+				return NewKittyThemer(config).ChangeMode, nil
+			},
+		},
+		{
+			name: "sway",
+			settings: func(config *Config) interface{} {
+				return struct{ Sway SwayConfig }{config.Sway}
+			},
+			setup: func(ctx context.Context, config *Config) (func(Mode) error, error) {
+				return NewSwayCommander(config, results).ChangeMode, nil
+			},
+		},
+		{
+			name: "templates",
+			settings: func(config *Config) interface{} {
+				return struct {
+					Templates []Template
+					Vars      map[Mode]map[string]string
+				}{config.Templates, config.Vars}
+			},
+			setup: func(ctx context.Context, config *Config) (func(Mode) error, error) {
+				return NewTemplateRenderer(config, scheduler.SunTimes).ChangeMode, nil
+			},
+		},
+		{
+			name: "gnomesync",
+			settings: func(config *Config) interface{} {
+				return struct{ GnomeSync bool }{config.GnomeSync}
+			},
+			setup: func(ctx context.Context, config *Config) (func(Mode) error, error) {
+				if !config.GnomeSync {
+					return nil, nil
+				}
+				gnome := NewGnomeSync(service.OverrideMode)
+				if err := gnome.Start(ctx); err != nil {
+					return nil, fmt.Errorf("could not follow GNOME's color-scheme: %v", err)
+				}
+				return gnome.ChangeMode, nil
+			},
+		},
+		{
+			name: "kdesync",
+			settings: func(config *Config) interface{} {
+				return struct{ KdeSync bool }{config.KdeSync}
+			},
+			setup: func(ctx context.Context, config *Config) (func(Mode) error, error) {
+				if !config.KdeSync {
+					return nil, nil
+				}
+				kde := NewKdeSync(service.OverrideMode)
+				kde.ChangeMode(service.CurrentMode())
+				if err := kde.Start(ctx); err != nil {
+					return nil, fmt.Errorf("could not follow KDE colour scheme: %v", err)
+				}
+				return kde.ChangeMode, nil
+			},
+		},
+		{
+			name: "dbusserver",
+			settings: func(config *Config) interface{} {
+				return struct{ DBusServer bool }{config.DBusServer}
+			},
+			setup: func(ctx context.Context, config *Config) (func(Mode) error, error) {
+				if !config.DBusServer {
+					log.Println("Running without D-Bus server.")
+					daemon.setDBusServer(nil)
+					return nil, nil
+				}
+				log.Println("Running with D-Bus server.")
+				info := DaemonInfo{Version: daemon.version, Capabilities: config.Capabilities()}
+				handle, err := NewDbusServer(ctx, service, scheduler, info, daemon.replace, daemon.reapply, daemon.Reload)
+				if err != nil {
+					return nil, err
+				}
+				go daemon.watchReplaced(ctx, handle.Replaced())
+				daemon.setDBusServer(handle)
+				return handle.ChangeMode, nil
+			},
+			// Leave enough time to reply to a Reload call which disabled it.
+			linger: time.Second,
+		},
+		{
+			name: "portal",
+			settings: func(config *Config) interface{} {
+				return struct{ Portal bool }{config.Portal}
+			},
+			setup: func(ctx context.Context, config *Config) (func(Mode) error, error) {
+				if !config.Portal {
+					log.Println("Running without XDG portal.")
+					return nil, nil
+				}
+				log.Println("Running with XDG portal.")
+				portal, err := NewPortal(ctx, service.CurrentMode(), daemon.replace)
+				if err != nil {
+					return nil, err
+				}
+				go daemon.watchReplaced(ctx, portal.Replaced())
+				return portal.ChangeMode, nil
+			},
+		},
+		{
+			name: "portalsource",
+			settings: func(config *Config) interface{} {
+				return struct{ PortalSource string }{config.PortalSource}
+			},
+			setup: func(ctx context.Context, config *Config) (func(Mode) error, error) {
+				if config.PortalSource == "" {
+					return nil, nil
+				}
+				mirror := NewPortalMirror(config.PortalSource, service.OverrideMode)
+				mirror.ChangeMode(service.CurrentMode())
+				if err := mirror.Start(ctx); err != nil {
+					return nil, fmt.Errorf("could not follow %v: %v", config.PortalSource, err)
+				}
+				return mirror.ChangeMode, nil
+			},
+		},
+	}
+}
+
+// Set up all components, and register them as listeners of the service.
+//
+// Failing to set up the D-Bus server or the portal is fatal, since another
+// instance is most likely running. Other failures are only logged.
+func (daemon *Daemon) Start() error {
+	daemon.mu.Lock()
+	config := daemon.config
+	daemon.mu.Unlock()
+
+	for _, c := range daemon.components {
+		if _, err := c.configure(daemon.ctx, &config); err != nil {
+			if c.name == "dbusserver" || c.name == "portal" {
+				return err
+			}
+			log.Println(err)
+		}
+		daemon.service.AddListener(c.ChangeMode)
+	}
+	daemon.updateCapabilities(&config)
+	return nil
+}
+
+// Read the configuration again, and set up anything that has changed. The
+// scheduler is updated if the location, times or use of geoclue have changed.
+//
+// If the configuration cannot be read or is invalid, nothing changes.
+// Components which fail to be set up are disabled, and set up again on the
+// next reload.
+func (daemon *Daemon) Reload() error {
+	daemon.reloadMu.Lock()
+	defer daemon.reloadMu.Unlock()
+
+	config, err := LoadConfig()
+	if err != nil {
+		return fmt.Errorf("failed to reload configuration: %v", err)
+	}
+
+	daemon.mu.Lock()
+	previous := daemon.config
+	daemon.mu.Unlock()
+
+	oldHash, err := previous.Hash()
+	if err != nil {
+		return fmt.Errorf("failed to hash configuration: %v", err)
+	}
+	newHash, err := config.Hash()
+	if err != nil {
+		return fmt.Errorf("failed to hash configuration: %v", err)
+	}
+	if oldHash != newHash {
+		log.Println("Reloading configuration.")
+	} else if daemon.anyFailed() {
+		log.Println("Configuration has not changed; retrying failed components.")
+	} else {
+		log.Println("Configuration has not changed.")
+		return nil
+	}
+
+	var problems []string
+	if err := daemon.reschedule(&previous, &config); err != nil {
+		problems = append(problems, err.Error())
+	}
+
+	mode := daemon.service.CurrentMode()
+	for _, c := range daemon.components {
+		changed, err := c.configure(daemon.ctx, &config)
+		if err != nil {
+			problems = append(problems, err.Error())
+			continue
+		}
+		if !changed {
+			continue
+		}
+		log.Printf("Reconfigured %v.\n", c.name)
+		if mode != NULL {
+			if err := c.ChangeMode(mode); err != nil {
+				problems = append(problems, fmt.Sprintf("%v: %v", c.name, err))
+			}
+		}
+	}
+
+	daemon.mu.Lock()
+	daemon.config = config
+	daemon.mu.Unlock()
+	daemon.updateCapabilities(&config)
+
+	if len(problems) > 0 {
+		return fmt.Errorf("failed to apply configuration:\n  %v", strings.Join(problems, "\n  "))
+	}
+	return nil
+}
+
+// Returns the capabilities enabled by `config`, except for those of components
+// which failed to be set up.
+func (daemon *Daemon) capabilities(config *Config) []string {
+	failed := make(map[string]bool)
+	for _, c := range daemon.components {
+		if c.failed() {
+			failed[c.name] = true
+		}
+	}
+
+	var capabilities []string
+	for _, name := range config.Capabilities() {
+		component := name
+		if name == "hooks" {
+			component = "scripts"
+		}
+		if !failed[component] {
+			capabilities = append(capabilities, name)
+		}
+	}
+	return capabilities
+}
+
+// Announce the current capabilities via the D-Bus server, if it is enabled.
+func (daemon *Daemon) updateCapabilities(config *Config) {
+	daemon.mu.Lock()
+	handle := daemon.dbusServer
+	daemon.mu.Unlock()
+	if handle != nil {
+		handle.ChangeCapabilities(daemon.capabilities(config))
+	}
+}
+
+// Returns true if any component failed to be set up.
+func (daemon *Daemon) anyFailed() bool {
+	for _, c := range daemon.components {
+		if c.failed() {
+			return true
+		}
+	}
+	return false
+}
+
+// Pass changes to the location, custom times or use of geoclue on to the
+// scheduler.
+func (daemon *Daemon) reschedule(previous, config *Config) error {
+	schedule := func(config *Config) (string, error) {
+		return rxhash.HashStruct(struct {
+			Lat, Lng        *float64
+			Sunrise, Sunset *string
+		}{config.Lat, config.Lng, config.Sunrise, config.Sunset})
+	}
+	oldSchedule, err := schedule(previous)
+	if err != nil {
+		return fmt.Errorf("failed to hash schedule: %v", err)
+	}
+	newSchedule, err := schedule(config)
+	if err != nil {
+		return fmt.Errorf("failed to hash schedule: %v", err)
+	}
+
+	if oldSchedule != newSchedule {
+		location, configTime, err := config.GetLocation()
+		if err != nil {
+			location, configTime = nil, nil
+		}
+		if location != nil {
+			daemon.scheduler.SetLocation(LocationInfo{
+				Location:  *location,
+				Source:    LocationFromConfig,
+				Timestamp: time.Now(),
+			})
+		}
+		daemon.scheduler.SetTime(configTime)
+	}
+
+	if previous.UseGeoclue != config.UseGeoclue {
+		return daemon.scheduler.SetUseGeoclue(config.UseGeoclue)
+	}
+	return nil
+}
+
+// Closed when another instance has taken over any of the daemon's D-Bus names,
+// after which the daemon should exit.
+func (daemon *Daemon) Replaced() <-chan struct{} {
+	return daemon.replaced
+}
+
+// Wait until `replaced` is closed, unless `ctx` is cancelled first.
+func (daemon *Daemon) watchReplaced(ctx context.Context, replaced <-chan struct{}) {
+	select {
+	case <-ctx.Done():
+	case <-replaced:
+		daemon.replacedOnce.Do(func() { close(daemon.replaced) })
+	}
+}
+
+// Run scripts for the current mode again, using the current script runner.
+func (daemon *Daemon) reapply(name string) error {
+	daemon.mu.Lock()
+	runner := daemon.runner
+	daemon.mu.Unlock()
+	if runner == nil {
+		return fmt.Errorf("scripts are not set up")
+	}
+	return runner.Reapply(name)
+}
+
+func (daemon *Daemon) setDBusServer(handle *DBusHandle) {
+	daemon.mu.Lock()
+	defer daemon.mu.Unlock()
+	daemon.dbusServer = handle
+}
+
+// Pass a new location on to the current D-Bus server, if any.
+func (daemon *Daemon) changeLocation(location LocationInfo) {
+	daemon.mu.Lock()
+	handle := daemon.dbusServer
+	daemon.mu.Unlock()
+	if handle != nil {
+		handle.ChangeLocation(location)
+	}
+}
@@ -0,0 +1,173 @@
+package darkman
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDaemonReload(t *testing.T) {
+	configHome := t.TempDir()
+	t.Setenv("XDG_CONFIG_HOME", configHome)
+	if err := os.Mkdir(filepath.Join(configHome, "darkman"), 0755); err != nil {
+		t.Fatal("failed to create config directory:", err)
+	}
+	dir := t.TempDir()
+	for _, name := range []string{"first-dark", "first-light", "second-dark", "second-light"} {
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0644); err != nil {
+			t.Fatal("failed to write test file:", err)
+		}
+	}
+	writeConfig := func(links ...string) {
+		yaml := "dbusserver: false\nportal: false\nlinks:\n"
+		for _, link := range links {
+			yaml += fmt.Sprintf("  - link: %v\n    dark: %v-dark\n    light: %v-light\n", filepath.Join(dir, link), link, link)
+		}
+		if err := os.WriteFile(filepath.Join(configHome, "darkman/config.yaml"), []byte(yaml), 0644); err != nil {
+			t.Fatal("failed to write config:", err)
+		}
+	}
+	// Listeners run asynchronously, so wait a little for the link to change.
+	target := func(link, want string) string {
+		var target string
+		for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+			if target, _ = os.Readlink(filepath.Join(dir, link)); target == want {
+				break
+			}
+		}
+		return target
+	}
+
+	writeConfig("first")
+	config, err := LoadConfig()
+	if err != nil {
+		t.Fatal("failed to load config:", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	service := NewService(DARK)
+	scheduler := NewScheduler(nil, nil, service.ChangeMode)
+	daemon := NewDaemon(ctx, "test", false, config, service, scheduler, LoadResults())
+	if err := daemon.Start(); err != nil {
+		t.Fatal("failed to start:", err)
+	}
+	if got := target("first", "first-dark"); got != "first-dark" {
+		t.Errorf("want first link applied on start, got %q", got)
+	}
+
+	if err := daemon.Reload(); err != nil {
+		t.Error("want no error reloading an unchanged config, got", err)
+	}
+
+	// New links are applied immediately, and removed ones are no longer
+	// switched.
+	writeConfig("second")
+	if err := daemon.Reload(); err != nil {
+		t.Fatal("failed to reload:", err)
+	}
+	if got := target("second", "second-dark"); got != "second-dark" {
+		t.Errorf("want second link applied on reload, got %q", got)
+	}
+	service.OverrideMode(LIGHT)
+	if got := target("second", "second-light"); got != "second-light" {
+		t.Errorf("want second link switched, got %q", got)
+	}
+	if got := target("first", "first-dark"); got != "first-dark" {
+		t.Errorf("want first link left alone after reload, got %q", got)
+	}
+
+	// An invalid config is rejected, and the previous one stays in effect.
+	if err := os.WriteFile(filepath.Join(configHome, "darkman/config.yaml"), []byte("bogus: true\n"), 0644); err != nil {
+		t.Fatal("failed to write config:", err)
+	}
+	if err := daemon.Reload(); err == nil || !strings.Contains(err.Error(), "bogus") {
+		t.Errorf("want an error for an unknown field, got %v", err)
+	}
+	service.OverrideMode(DARK)
+	if got := target("second", "second-dark"); got != "second-dark" {
+		t.Errorf("want second link still switched, got %q", got)
+	}
+}
+
+func TestDaemonReloadRetriesFailures(t *testing.T) {
+	configHome := t.TempDir()
+	t.Setenv("XDG_CONFIG_HOME", configHome)
+	if err := os.Mkdir(filepath.Join(configHome, "darkman"), 0755); err != nil {
+		t.Fatal("failed to create config directory:", err)
+	}
+	yaml := "dbusserver: false\nportal: false\n"
+	if err := os.WriteFile(filepath.Join(configHome, "darkman/config.yaml"), []byte(yaml), 0644); err != nil {
+		t.Fatal("failed to write config:", err)
+	}
+	config, err := LoadConfig()
+	if err != nil {
+		t.Fatal("failed to load config:", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	service := NewService(DARK)
+	daemon := NewDaemon(ctx, "test", false, config, service, NewScheduler(nil, nil, service.ChangeMode), LoadResults())
+
+	// Fails to set up the first time only.
+	setups := 0
+	daemon.components = []*component{{
+		name:     "flaky",
+		settings: func(config *Config) interface{} { return struct{}{} },
+		setup: func(ctx context.Context, config *Config) (func(Mode) error, error) {
+			setups++
+			if setups == 1 {
+				return nil, fmt.Errorf("not yet")
+			}
+			return nil, nil
+		},
+	}}
+	if err := daemon.Start(); err != nil {
+		t.Fatal("failed to start:", err)
+	}
+
+	// Retried even though the configuration has not changed.
+	if err := daemon.Reload(); err != nil {
+		t.Fatal("failed to reload:", err)
+	}
+	if setups != 2 {
+		t.Errorf("want the failed component set up again, got %d setups", setups)
+	}
+	if err := daemon.Reload(); err != nil {
+		t.Fatal("failed to reload:", err)
+	}
+	if setups != 2 {
+		t.Errorf("want no further setups once successful, got %d setups", setups)
+	}
+}
+
+func TestDaemonCapabilities(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	config := Default()
+	config.DBusServer, config.Portal = false, false
+	config.GnomeSync, config.KdeSync = true, true
+	service := NewService(DARK)
+	daemon := NewDaemon(ctx, "test", false, config, service, NewScheduler(nil, nil, service.ChangeMode), LoadResults())
+
+	// Replace the real components, which would need a session bus.
+	setup := func(err error) func(context.Context, *Config) (func(Mode) error, error) {
+		return func(context.Context, *Config) (func(Mode) error, error) { return nil, err }
+	}
+	settings := func(*Config) interface{} { return struct{}{} }
+	daemon.components = []*component{
+		{name: "gnomesync", settings: settings, setup: setup(fmt.Errorf("no dconf"))},
+		{name: "kdesync", settings: settings, setup: setup(nil)},
+	}
+	if err := daemon.Start(); err != nil {
+		t.Fatal("failed to start:", err)
+	}
+
+	if got := strings.Join(daemon.capabilities(&config), " "); got != "scripts kdesync" {
+		t.Errorf("want only components which started successfully, got %v", got)
+	}
+}
@@ -0,0 +1,146 @@
+package darkman
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/adrg/xdg"
+)
+
+// The outcome of running a script for a given mode.
+type Result struct {
+	// The last mode which was applied successfully.
+	Applied Mode `json:"applied"`
+	// When the script last ran.
+	LastRun time.Time `json:"last_run"`
+	// Error from the last run, if it failed.
+	LastError string `json:"last_error,omitempty"`
+}
+
+// Keeps track of the last mode applied by each script.
+//
+// Results are persisted across restarts, so that scripts which have already
+// applied a mode need not run again at start-up. Each result is keyed by the
+// kind of what produced it and its name (e.g.: "script:theme.sh", or
+// "hook:kitty"), so that a script and a hook with the same name do not
+// overwrite each other. Manual runs are prefixed with "manual:", and do not
+// affect which scripts are skipped.
+type Results struct {
+	path    string
+	results map[string]Result
+	mu      sync.Mutex
+}
+
+// Load previous results from the state directory.
+//
+// If they cannot be loaded, all scripts are considered to have never run.
+func LoadResults() *Results {
+	results := Results{results: make(map[string]Result)}
+
+	path, err := xdg.StateFile("darkman/results.json")
+	if err != nil {
+		log.Printf("Failed to determine location for results file: %v\n", err)
+		return &results
+	}
+	results.path = path
+
+	if err := results.load(); err != nil {
+		log.Println(err)
+	}
+	return &results
+}
+
+// Read results from disk, replacing those in memory. A missing file is not an
+// error.
+func (results *Results) load() error {
+	data, err := os.ReadFile(results.path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("error reading results file: %v", err)
+	}
+
+	loaded := make(map[string]Result)
+	if err = json.Unmarshal(data, &loaded); err != nil {
+		return fmt.Errorf("error parsing results file: %v", err)
+	}
+	results.results = loaded
+	return nil
+}
+
+// Returns the last mode successfully applied by a script, or NULL if it has
+// never run successfully.
+func (results *Results) Applied(name string) Mode {
+	results.mu.Lock()
+	defer results.mu.Unlock()
+
+	if result, ok := results.results[name]; ok {
+		return result.Applied
+	}
+	return NULL
+}
+
+// Record the outcome of running a script for a given mode.
+//
+// Results are read from disk again first, so that those recorded by other
+// processes (e.g.: `darkman scripts run`) are not lost.
+func (results *Results) Record(name string, mode Mode, err error) {
+	results.mu.Lock()
+	defer results.mu.Unlock()
+
+	if results.path != "" {
+		if err := results.load(); err != nil {
+			log.Println(err)
+		}
+	}
+
+	result, ok := results.results[name]
+	if !ok {
+		result.Applied = NULL
+	}
+	result.LastRun = time.Now()
+	if err != nil {
+		result.LastError = err.Error()
+	} else {
+		result.Applied = mode
+		result.LastError = ""
+	}
+	results.results[name] = result
+
+	if err := results.save(); err != nil {
+		log.Println(err)
+	}
+}
+
+// Returns a copy of all known results.
+func (results *Results) All() map[string]Result {
+	results.mu.Lock()
+	defer results.mu.Unlock()
+
+	all := make(map[string]Result, len(results.results))
+	for name, result := range results.results {
+		all[name] = result
+	}
+	return all
+}
+
+func (results *Results) save() error {
+	if results.path == "" {
+		return nil
+	}
+
+	data, err := json.Marshal(results.results)
+	if err != nil {
+		return fmt.Errorf("failed to serialise results: %v", err)
+	}
+
+	// Written atomically, since other processes read and write it too.
+	if err = writeFileAtomic(results.path, data, os.FileMode(0600)); err != nil {
+		return fmt.Errorf("failed to save results: %v", err)
+	}
+	return nil
+}
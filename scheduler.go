@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"log"
+	"sync"
 	"time"
 
 	"github.com/sj14/astral"
@@ -81,20 +82,122 @@ func CalculateCurrentMode(nextSunrise time.Time, nextSundown time.Time) Mode {
 // Scheduler handles setting timers based on the current location, and
 // trigering changes based on the current location and sun position.
 type Scheduler struct {
-	currentLocation *geoclue.Location
+	currentLocation *LocationInfo
 	currentTime     *Time
 	changeCallback  func(Mode)
 	latestTimer     *boottimer.Timer
+	newLocations    chan (LocationInfo)
+	newTimes        chan (*Time)
+	// Called with each new location, including unchanged ones.
+	locationListeners []func(LocationInfo)
+	// The context passed to Start.
+	ctx context.Context
+	mu  sync.Mutex
+	// Stops obtaining locations via geoclue, if in use.
+	stopGeoclue context.CancelFunc
+	geoclueMu   sync.Mutex
 }
 
-// The scheduler schedules timer to wake up in time for the next sundown/sunrise.
-func NewScheduler(ctx context.Context, initialLocation *geoclue.Location, initialTime *Time, changeCallback func(Mode), useGeoclue bool) error {
-	scheduler := Scheduler{
-		changeCallback: changeCallback,
+// Creates a new scheduler. Transitions are not scheduled until it is started.
+func NewScheduler(initialLocation *LocationInfo, initialTime *Time, changeCallback func(Mode)) *Scheduler {
+	return &Scheduler{
+		currentLocation: initialLocation,
+		currentTime:     initialTime,
+		changeCallback:  changeCallback,
+		newLocations:    make(chan (LocationInfo)),
+		newTimes:        make(chan (*Time)),
 	}
+}
+
+// Add a callback to be run each time a location is obtained. Must be called
+// before the scheduler is started.
+func (scheduler *Scheduler) AddLocationListener(listener func(LocationInfo)) {
+	scheduler.mu.Lock()
+	defer scheduler.mu.Unlock()
+	scheduler.locationListeners = append(scheduler.locationListeners, listener)
+}
+
+// Use a new location, just as if it had been obtained via geoclue. Blocks
+// until the scheduler has received it, so the scheduler must be running. The
+// location is discarded if the scheduler has stopped.
+func (scheduler *Scheduler) SetLocation(location LocationInfo) {
+	select {
+	case scheduler.newLocations <- location:
+	case <-scheduler.done():
+		log.Println("Scheduler has stopped; ignoring new location.")
+	}
+}
+
+// Use new custom sunrise and sunset times, or stop using them if nil. Blocks
+// until the scheduler has received them, so the scheduler must be running.
+// The times are discarded if the scheduler has stopped.
+func (scheduler *Scheduler) SetTime(configTime *Time) {
+	select {
+	case scheduler.newTimes <- configTime:
+	case <-scheduler.done():
+		log.Println("Scheduler has stopped; ignoring new times.")
+	}
+}
+
+// Returns a channel which is closed once the scheduler has stopped. It is nil
+// if the scheduler has not been started.
+func (scheduler *Scheduler) done() <-chan struct{} {
+	scheduler.mu.Lock()
+	defer scheduler.mu.Unlock()
+	if scheduler.ctx == nil {
+		return nil
+	}
+	return scheduler.ctx.Done()
+}
+
+// Start or stop obtaining locations via geoclue. The scheduler must be
+// running.
+func (scheduler *Scheduler) SetUseGeoclue(enabled bool) error {
+	scheduler.geoclueMu.Lock()
+	defer scheduler.geoclueMu.Unlock()
+
+	if enabled == (scheduler.stopGeoclue != nil) {
+		return nil
+	}
+	if !enabled {
+		log.Println("Stopping geoclue.")
+		scheduler.stopGeoclue()
+		scheduler.stopGeoclue = nil
+		return nil
+	}
+
+	scheduler.mu.Lock()
+	ctx, cancel := context.WithCancel(scheduler.ctx)
+	scheduler.mu.Unlock()
+	if err := GetLocations(ctx, scheduler.newLocations); err != nil {
+		cancel()
+		return fmt.Errorf("could not start location service: %v", err)
+	}
+	scheduler.stopGeoclue = cancel
+	return nil
+}
+
+// Returns the current location, or nil if it is not known.
+func (scheduler *Scheduler) Location() *LocationInfo {
+	scheduler.mu.Lock()
+	defer scheduler.mu.Unlock()
+	if scheduler.currentLocation == nil {
+		return nil
+	}
+	location := *scheduler.currentLocation
+	return &location
+}
+
+// Start scheduling timers to wake up in time for the next sundown/sunrise.
+//
+// If there is no source for locations nor times, no transitions are scheduled
+// until a location is set via SetLocation.
+func (scheduler *Scheduler) Start(ctx context.Context, useGeoclue bool) error {
+	scheduler.mu.Lock()
+	initialLocation, initialTime := scheduler.currentLocation, scheduler.currentTime
+	scheduler.ctx = ctx
+	scheduler.mu.Unlock()
 
-	newLocations := make(chan (geoclue.Location))
-	chanTime := make(chan (Time))
 	// Alarms wake us up when it's time for the next transition.
 	go func() {
 		for {
@@ -105,40 +208,73 @@ func NewScheduler(ctx context.Context, initialLocation *geoclue.Location, initia
 				scheduler.stop()
 				return
 				// The timer itself also has ctx.
-			case loc := <-newLocations:
-				if scheduler.currentLocation != nil && loc == *scheduler.currentLocation {
+			case loc := <-scheduler.newLocations:
+				scheduler.mu.Lock()
+				// The initial location is only a placeholder until the
+				// first tick, so is never considered unchanged.
+				unchanged := scheduler.latestTimer != nil && scheduler.currentLocation != nil && loc.Location == scheduler.currentLocation.Location
+				scheduler.currentLocation = &loc
+				listeners := scheduler.locationListeners
+				scheduler.mu.Unlock()
+
+				for _, listener := range listeners {
+					listener(loc)
+				}
+				if unchanged {
 					log.Println("Location has not changed, nothing to do.")
 				} else {
-					scheduler.currentLocation = &loc
 					scheduler.Tick(ctx)
 				}
-			case tm := <-chanTime:
-				scheduler.currentTime = &tm
+			case tm := <-scheduler.newTimes:
+				scheduler.mu.Lock()
+				scheduler.currentTime = tm
+				scheduler.mu.Unlock()
 				scheduler.Tick(ctx)
 			}
 		}
 	}()
 
 	if useGeoclue {
-		if err := GetLocations(ctx, newLocations); err != nil {
-			return fmt.Errorf("could not start location service: %v", err)
-		}
-		return nil
+		return scheduler.SetUseGeoclue(true)
 	}
 
 	if initialLocation != nil {
 		log.Println("Not using geoclue; using static location.")
-		newLocations <- *initialLocation
+		scheduler.newLocations <- *initialLocation
 		return nil
 	}
 
 	if initialTime != nil {
 		log.Println("Not using geoclue or static location; using custom sunrise and sunset.")
-		chanTime <- *initialTime
+		scheduler.newTimes <- initialTime
 		return nil
 	}
 
-	return fmt.Errorf("no location source available")
+	log.Println("No location source available; waiting for one to be set via D-Bus.")
+	return nil
+}
+
+// Returns the times of the next sunrise and sunset, or zero values if they
+// cannot be determined.
+func (scheduler *Scheduler) SunTimes() (sunrise time.Time, sunset time.Time) {
+	scheduler.mu.Lock()
+	location, configTime := scheduler.currentLocation, scheduler.currentTime
+	scheduler.mu.Unlock()
+
+	var err error
+	now := time.Now()
+	if configTime != nil {
+		sunrise, sunset, err = NextSunriseAndSundownTime(*configTime, now)
+	} else if location != nil {
+		sunrise, sunset, err = NextSunriseAndSundown(location.Location, now)
+	} else {
+		return
+	}
+	if err != nil {
+		log.Printf("Error calculating next sundown/sunrise: %v", err)
+		return time.Time{}, time.Time{}
+	}
+	return
 }
 
 // A single tick.
@@ -146,7 +282,11 @@ func NewScheduler(ctx context.Context, initialLocation *geoclue.Location, initia
 // Update the mode based on the current time, execute transition, and set the
 // timer for the next tick.
 func (handler *Scheduler) Tick(ctx context.Context) {
-	if handler.currentLocation == nil && handler.currentTime == nil {
+	handler.mu.Lock()
+	location, configTime := handler.currentLocation, handler.currentTime
+	handler.mu.Unlock()
+
+	if location == nil && configTime == nil {
 		log.Println("No location or time yet, nothing to do.")
 		return
 	}
@@ -163,10 +303,10 @@ func (handler *Scheduler) Tick(ctx context.Context) {
 	// needs to be well tested.
 	var err error
 	var sunrise, sundown time.Time
-	if handler.currentTime != nil {
-		sunrise, sundown, err = NextSunriseAndSundownTime(*handler.currentTime, now.Add(time.Minute))
+	if configTime != nil {
+		sunrise, sundown, err = NextSunriseAndSundownTime(*configTime, now.Add(time.Minute))
 	} else {
-		sunrise, sundown, err = NextSunriseAndSundown(*handler.currentLocation, now.Add(time.Minute))
+		sunrise, sundown, err = NextSunriseAndSundown(location.Location, now.Add(time.Minute))
 	}
 	if err != nil {
 		log.Printf("Error calculating next sundown/sunrise: %v", err)
@@ -216,10 +356,19 @@ func (handler *Scheduler) setNextAlarm(ctx context.Context, now time.Time, curMo
 
 	// Need to move the timer into the heap before assigning.
 	timer := boottimer.SetTimer(sleepFor)
+	handler.mu.Lock()
+	// Ticks also happen when the location changes, which replaces the
+	// previous alarm.
+	if handler.latestTimer != nil {
+		handler.latestTimer.Delete()
+	}
 	handler.latestTimer = &timer
+	handler.mu.Unlock()
 }
 
 func (handler *Scheduler) stop() {
+	handler.mu.Lock()
+	defer handler.mu.Unlock()
 	if handler.latestTimer != nil {
 		handler.latestTimer.Delete()
 	}
@@ -0,0 +1,140 @@
+package darkman
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/adrg/xdg"
+)
+
+// Amount of log files kept for each script. Older ones are deleted.
+const scriptLogRetention = 10
+
+// Maximum size of a single log file. Any further output is discarded.
+const scriptLogMaxSize = 1 << 20
+
+// Directories, relative to $XDG_STATE_HOME, in which logs are kept. Pre-hooks
+// run before every automatic transition, so their logs are kept apart from
+// those of transition scripts, which would otherwise be pruned by them.
+const (
+	scriptLogDir  = "darkman/scripts"
+	preHookLogDir = "darkman/pre-hooks"
+)
+
+// Writes each line to the service's log, prefixed with a script's name.
+type prefixWriter struct {
+	prefix string
+	buf    []byte
+}
+
+func (w *prefixWriter) Write(p []byte) (int, error) {
+	w.buf = append(w.buf, p...)
+	for {
+		i := bytes.IndexByte(w.buf, '\n')
+		if i < 0 {
+			break
+		}
+		log.Printf("[%v] %s", w.prefix, w.buf[:i])
+		w.buf = w.buf[i+1:]
+	}
+	return len(p), nil
+}
+
+// Log any trailing output which did not end in a newline.
+func (w *prefixWriter) Flush() {
+	if len(w.buf) > 0 {
+		log.Printf("[%v] %s", w.prefix, w.buf)
+		w.buf = nil
+	}
+}
+
+// Like io.LimitedReader, but for writes. Output beyond the limit is silently
+// discarded, so that a noisy script never fails due to its log being full.
+type limitedWriter struct {
+	w         io.Writer
+	remaining int64
+}
+
+func (w *limitedWriter) Write(p []byte) (int, error) {
+	if w.remaining <= 0 {
+		return len(p), nil
+	}
+	chunk := p
+	if int64(len(chunk)) > w.remaining {
+		chunk = chunk[:w.remaining]
+	}
+	n, err := w.w.Write(chunk)
+	w.remaining -= int64(n)
+	if err != nil {
+		return n, err
+	}
+	return len(p), nil
+}
+
+// Serialises writes from multiple goroutines.
+type syncWriter struct {
+	w  io.Writer
+	mu sync.Mutex
+}
+
+func (w *syncWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.w.Write(p)
+}
+
+// Returns the name of a script without its extension. Used as a prefix when
+// logging its output.
+func scriptLogPrefix(name string) string {
+	return strings.TrimSuffix(name, filepath.Ext(name))
+}
+
+// Create a new log file for a single run of a script.
+//
+// Log files are kept in $XDG_STATE_HOME/$DIR/$NAME/, one per run, where $DIR
+// is `dir` (e.g.: scriptLogDir). Old log files beyond the retention limit are
+// deleted.
+func createScriptLog(dir, name string, mode Mode) (*os.File, error) {
+	fileName := fmt.Sprintf("%v-%v.log", time.Now().Format("20060102T150405.000"), mode)
+	logPath, err := xdg.StateFile(filepath.Join(dir, name, fileName))
+	if err != nil {
+		return nil, fmt.Errorf("failed to determine location for script log: %v", err)
+	}
+
+	pruneScriptLogs(filepath.Dir(logPath), scriptLogRetention-1)
+
+	return os.OpenFile(logPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+}
+
+// Delete the oldest log files in a directory, keeping only `keep` of them.
+func pruneScriptLogs(dir string, keep int) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		log.Printf("Error reading script logs in %v: %v\n", dir, err)
+		return
+	}
+
+	var logs []string
+	for _, entry := range entries {
+		if !entry.IsDir() && strings.HasSuffix(entry.Name(), ".log") {
+			logs = append(logs, entry.Name())
+		}
+	}
+	// File names start with a timestamp, so they sort chronologically.
+	sort.Strings(logs)
+
+	for len(logs) > keep {
+		if err := os.Remove(filepath.Join(dir, logs[0])); err != nil {
+			log.Printf("Error removing old script log: %v\n", err)
+		}
+		logs = logs[1:]
+	}
+}
@@ -0,0 +1,166 @@
+package darkman
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/adrg/xdg"
+)
+
+func TestPrefixWriter(t *testing.T) {
+	var output bytes.Buffer
+	log.SetOutput(&output)
+	flags := log.Flags()
+	log.SetFlags(0)
+	defer func() {
+		log.SetOutput(os.Stderr)
+		log.SetFlags(flags)
+	}()
+
+	cases := []struct {
+		name   string
+		writes []string
+		want   string
+	}{
+		{"single line", []string{"hello\n"}, "[theme] hello\n"},
+		{"several lines in one write", []string{"one\ntwo\n"}, "[theme] one\n[theme] two\n"},
+		{"line split across writes", []string{"hel", "lo\nwor", "ld\n"}, "[theme] hello\n[theme] world\n"},
+		{"empty line", []string{"\n"}, "[theme] \n"},
+		{"trailing output is flushed", []string{"one\ntw", "o"}, "[theme] one\n[theme] two\n"},
+	}
+
+	for _, c := range cases {
+		output.Reset()
+		w := &prefixWriter{prefix: "theme"}
+		for _, p := range c.writes {
+			if n, err := w.Write([]byte(p)); err != nil || n != len(p) {
+				t.Errorf("%v: want %d bytes written, got %d (%v)", c.name, len(p), n, err)
+			}
+		}
+		w.Flush()
+		if got := output.String(); got != c.want {
+			t.Errorf("%v: want %q, got %q", c.name, c.want, got)
+		}
+	}
+}
+
+func TestLimitedWriter(t *testing.T) {
+	cases := []struct {
+		name   string
+		limit  int64
+		writes []string
+		want   string
+	}{
+		{"below the limit", 10, []string{"abc", "def"}, "abcdef"},
+		{"exactly the limit", 6, []string{"abc", "def"}, "abcdef"},
+		{"truncated mid-write", 4, []string{"abc", "def"}, "abcd"},
+		{"discarded after the limit", 3, []string{"abc", "def", "ghi"}, "abc"},
+		{"no limit left", 0, []string{"abc"}, ""},
+		{"log file limit", scriptLogMaxSize, []string{strings.Repeat("x", scriptLogMaxSize-1), "yz"}, strings.Repeat("x", scriptLogMaxSize-1) + "y"},
+	}
+
+	for _, c := range cases {
+		var output bytes.Buffer
+		w := &limitedWriter{&output, c.limit}
+		for _, p := range c.writes {
+			// Writes always succeed in full, so that scripts never fail
+			// because their log is full.
+			if n, err := w.Write([]byte(p)); err != nil || n != len(p) {
+				t.Errorf("%v: want %d bytes written, got %d (%v)", c.name, len(p), n, err)
+			}
+		}
+		if got := output.String(); got != c.want {
+			t.Errorf("%v: want %d bytes, got %d", c.name, len(c.want), len(got))
+		}
+	}
+}
+
+func TestPruneScriptLogs(t *testing.T) {
+	cases := []struct {
+		name string
+		logs int
+		keep int
+		// Other files in the directory, which are never removed.
+		others []string
+		want   int
+	}{
+		{"nothing to prune", 3, scriptLogRetention, nil, 3},
+		{"exactly the retention", scriptLogRetention, scriptLogRetention, nil, scriptLogRetention},
+		{"oldest are pruned", 15, scriptLogRetention, nil, scriptLogRetention},
+		{"room for a new log", scriptLogRetention, scriptLogRetention - 1, nil, scriptLogRetention - 1},
+		{"other files are kept", 12, scriptLogRetention, []string{"notes.txt"}, scriptLogRetention},
+	}
+
+	for _, c := range cases {
+		dir := t.TempDir()
+		var names []string
+		for i := 0; i < c.logs; i++ {
+			names = append(names, fmt.Sprintf("20240101T0000%02d.000-dark.log", i))
+		}
+		for _, name := range append(names, c.others...) {
+			if err := os.WriteFile(filepath.Join(dir, name), nil, 0600); err != nil {
+				t.Fatal("failed to write test file:", err)
+			}
+		}
+
+		pruneScriptLogs(dir, c.keep)
+
+		var logs, others []string
+		entries, _ := os.ReadDir(dir)
+		for _, entry := range entries {
+			if strings.HasSuffix(entry.Name(), ".log") {
+				logs = append(logs, entry.Name())
+			} else {
+				others = append(others, entry.Name())
+			}
+		}
+		sort.Strings(logs)
+		if len(logs) != c.want {
+			t.Errorf("%v: want %d logs kept, got %d", c.name, c.want, len(logs))
+		} else if len(logs) > 0 && logs[len(logs)-1] != names[len(names)-1] {
+			t.Errorf("%v: want the newest log kept, got %v", c.name, logs)
+		}
+		if len(others) != len(c.others) {
+			t.Errorf("%v: want other files kept, got %v", c.name, others)
+		}
+	}
+}
+
+func TestCreateScriptLogSeparatesPreHooks(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("XDG_STATE_HOME", dir)
+	xdg.Reload()
+	defer xdg.Reload()
+
+	// Frequent pre-hook runs never prune the logs of a script with the same
+	// name.
+	script, err := createScriptLog(scriptLogDir, "theme", DARK)
+	if err != nil {
+		t.Fatal("failed to create script log:", err)
+	}
+	script.Close()
+	for i := 0; i < scriptLogRetention+1; i++ {
+		hook, err := createScriptLog(preHookLogDir, "theme", DARK)
+		if err != nil {
+			t.Fatal("failed to create pre-hook log:", err)
+		}
+		hook.Close()
+	}
+
+	if _, err := os.Stat(script.Name()); err != nil {
+		t.Errorf("want the script's log kept, got %v", err)
+	}
+	if filepath.Dir(script.Name()) != filepath.Join(dir, "darkman/scripts/theme") {
+		t.Errorf("unexpected location for script log: %v", script.Name())
+	}
+	hooks, _ := os.ReadDir(filepath.Join(dir, "darkman/pre-hooks/theme"))
+	if len(hooks) == 0 {
+		t.Error("want pre-hook logs in their own directory")
+	}
+}
@@ -1,69 +1,352 @@
 package darkman
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"io"
 	"log"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"sort"
+	"strings"
 	"sync"
+	"syscall"
+	"time"
 
 	"github.com/adrg/xdg"
 )
 
 var scriptsRunning sync.Mutex
 
-// Run transition scripts for a given mode.
+// A transition script found in one of the script directories, or a hook
+// defined in the configuration file.
+type Script struct {
+	// Name of the file. Scripts with the same name shadow each other.
+	Name string
+	// Full path to the script. Empty for hooks.
+	Path string
+	// Directory in which the script was found. Empty for hooks.
+	Dir string
+	// Scripts without an executable bit are never run, and disable any
+	// scripts that they shadow.
+	Executable bool
+	// Scripts with the same name in directories with lower precedence.
+	Shadowed []Script
+	// Time after which the script is killed. Zero means no timeout.
+	Timeout time.Duration
+	// Only set for hooks.
+	Hook *Hook
+}
+
+// Returns the command line used to run a script.
+func (script *Script) Command() []string {
+	if script.Hook != nil {
+		return script.Hook.Command
+	}
+	return []string{script.Path}
+}
+
+// Returns the name under which the outcome of running the script is recorded
+// in Results.
+func (script *Script) ResultKey() string {
+	if script.Hook != nil {
+		return "hook:" + script.Name
+	}
+	return "script:" + script.Name
+}
+
+// Returns the name under which the outcome of running the script manually
+// (e.g.: via `darkman scripts run`) is recorded in Results.
+func manualResultKey(script *Script) string {
+	return "manual:" + script.ResultKey()
+}
+
+// Returns all directories which may contain scripts for a given mode, in
+// increasing order of precedence:
 //
-// Fires up all scripts asyncrhonously and returns immediately.
-func RunScripts(mode Mode) error {
-	executables := make(map[string]string)
-	directories := make([]string, len(xdg.DataDirs)+1)
+//   - Each of $XDG_DATA_DIRS, in order.
+//   - $XDG_DATA_HOME.
+//   - $XDG_CONFIG_HOME/darkman.
+//   - Each of `extraDirs`, in order.
+func ScriptDirectories(mode Mode, extraDirs []string) []string {
+	return searchDirectories(fmt.Sprintf("%v-mode.d", mode), extraDirs)
+}
 
-	copy(directories, xdg.DataDirs)
-	directories[len(directories)-1] = xdg.DataHome
+// Returns all directories named `name` in which scripts are searched, in
+// increasing order of precedence.
+func searchDirectories(name string, extraDirs []string) []string {
+	var directories []string
+	directories = append(directories, xdg.DataDirs...)
+	directories = append(directories, xdg.DataHome)
+	directories = append(directories, filepath.Join(xdg.ConfigHome, "darkman"))
+	for _, dir := range extraDirs {
+		directories = append(directories, expandHome(dir))
+	}
 
-	for _, dir := range directories {
-		modeDir := filepath.Join(dir, fmt.Sprintf("%v-mode.d", mode))
+	for i, dir := range directories {
+		directories[i] = filepath.Join(dir, name)
+	}
+	return directories
+}
 
+// Find all scripts for a given mode.
+//
+// When two directories contain a file with the same name, the one in the
+// directory with higher precedence shadows the other one. Disabled scripts
+// (e.g.: those without an executable bit) are also returned.
+//
+// Scripts are sorted by name, which is also the order in which they run.
+func FindScripts(mode Mode, extraDirs []string) []Script {
+	return findScripts(ScriptDirectories(mode, extraDirs))
+}
+
+// Find all scripts in the given directories, which must be sorted in
+// increasing order of precedence.
+func findScripts(directories []string) []Script {
+	found := make(map[string]Script)
+
+	for _, modeDir := range directories {
 		files, err := os.ReadDir(modeDir)
 		if os.IsNotExist(err) {
 			continue
 		} else if err != nil {
-			log.Println(err.Error())
-			log.Printf("Error reading entries in %v: %v.\n", dir, err)
+			log.Printf("Error reading entries in %v: %v.\n", modeDir, err)
 		}
 
 		for _, file := range files {
-			filePath := fmt.Sprintf("%v/%v", modeDir, file.Name())
-			log.Printf("Found %v.", filePath)
+			filePath := filepath.Join(modeDir, file.Name())
 			ok, err := IsExecutable(filePath)
 			// Don't try to execute scripts that aren't executable
 			if err != nil {
 				log.Printf("%v: %s", filePath, err)
 			}
 
-			if ok {
-				executables[file.Name()] = filePath
-			} else {
-				delete(executables, file.Name())
+			script := Script{
+				Name:       file.Name(),
+				Path:       filePath,
+				Dir:        modeDir,
+				Executable: ok,
 			}
+			if previous, exists := found[file.Name()]; exists {
+				// Keep the list flat, with the highest precedence first.
+				shadowed := previous.Shadowed
+				previous.Shadowed = nil
+				script.Shadowed = append([]Script{previous}, shadowed...)
+			}
+			found[file.Name()] = script
 		}
 	}
 
-	go func() {
-		scriptsRunning.Lock()
-		defer scriptsRunning.Unlock()
+	scripts := make([]Script, 0, len(found))
+	for _, script := range found {
+		scripts = append(scripts, script)
+	}
+	sort.Slice(scripts, func(i, j int) bool {
+		return scripts[i].Name < scripts[j].Name
+	})
+
+	return scripts
+}
+
+// Returns only the scripts that would run for a given mode.
+func EnabledScripts(mode Mode, extraDirs []string) []Script {
+	return enabledOnly(FindScripts(mode, extraDirs))
+}
+
+func enabledOnly(scripts []Script) []Script {
+	var enabled []Script
+	for _, script := range scripts {
+		if script.Executable {
+			enabled = append(enabled, script)
+		}
+	}
+	return enabled
+}
+
+// Returns scripts which are enabled in `current`, but were not enabled in
+// `previous` (either because they did not exist, or were not executable).
+func newlyEnabled(previous, current []Script) []Script {
+	before := make(map[string]string)
+	for _, script := range enabledOnly(previous) {
+		before[script.Name] = script.Path
+	}
+
+	var added []Script
+	for _, script := range enabledOnly(current) {
+		if path, ok := before[script.Name]; !ok || path != script.Path {
+			added = append(added, script)
+		}
+	}
+	return added
+}
+
+// Wraps hooks from the configuration file so that they can run like scripts.
+func HookScripts(hooks []Hook) []Script {
+	var scripts []Script
+	for i := range hooks {
+		hook := &hooks[i]
+		if len(hook.Command) == 0 {
+			continue // Reported by Config.Validate.
+		}
+		scripts = append(scripts, Script{
+			Name:       hook.DisplayName(),
+			Executable: true,
+			Timeout:    hook.Timeout,
+			Hook:       hook,
+		})
+	}
+	return scripts
+}
+
+// Exit code used by scripts to indicate a temporary failure (EX_TEMPFAIL in
+// sysexits.h). Scripts which exit with this code are retried later.
+const exitTempFail = 75
+
+// Runs transition scripts, retrying those which fail transiently.
+type ScriptRunner struct {
+	retry      RetryConfig
+	hooks      map[Mode][]Hook
+	scriptDirs []string
+	vars       map[Mode]map[string]string
+	results    *Results
+	// The last mode for which scripts were run.
+	mode Mode
+	// Whether RunScripts has been called yet.
+	started bool
+	// Cancelled when the mode changes, to abandon pending retries.
+	ctx    context.Context
+	cancel context.CancelFunc
+	// Scripts found for each mode. Only populated while watching for
+	// changes; otherwise directories are scanned each time.
+	cache map[Mode][]Script
+	mu    sync.Mutex
+}
+
+// Creates a new ScriptRunner with the retry policy, hooks, variables and
+// additional script directories from `config`.
+//
+// The outcome of each script is saved into `results`.
+func NewScriptRunner(config *Config, results *Results) *ScriptRunner {
+	return &ScriptRunner{
+		retry:      config.Retry,
+		hooks:      config.Hooks,
+		scriptDirs: config.ScriptDirs,
+		vars:       config.Vars,
+		results:    results,
+		mode:       NULL,
+		ctx:        context.Background(),
+	}
+}
+
+// Returns all scripts and hooks that run for a given mode, in order.
+func (runner *ScriptRunner) Scripts(mode Mode) []Script {
+	runner.mu.Lock()
+	scripts, cached := runner.cache[mode]
+	runner.mu.Unlock()
+
+	if !cached {
+		scripts = FindScripts(mode, runner.scriptDirs)
+	}
+	return append(enabledOnly(scripts), HookScripts(runner.hooks[mode])...)
+}
+
+// Delay before rescanning directories after a change. A burst of changes
+// (e.g.: a file being written and then made executable) results in a single
+// rescan.
+const rescanDelay = 500 * time.Millisecond
+
+// Returns true if a change to `path` may affect which scripts exist.
+func affectsScripts(path string) bool {
+	return strings.HasSuffix(filepath.Base(path), "-mode.d") ||
+		strings.HasSuffix(filepath.Base(filepath.Dir(path)), "-mode.d")
+}
 
-		for _, executable := range executables {
-			log.Printf("Running %v...", executable)
+// Returns true if `path` is `dir` or one of its ancestors.
+func isAncestor(path, dir string) bool {
+	return path == dir || strings.HasPrefix(dir, path+string(filepath.Separator))
+}
 
-			cmd := exec.Command(executable)
-			cmd.Stdout = os.Stdout
-			cmd.Stderr = os.Stderr
+// Watch `dir`, or its nearest existing ancestor if it does not exist, so that
+// its creation is noticed.
+func watchNearest(watcher *dirWatcher, dir string) {
+	for {
+		err := watcher.Add(dir)
+		if err == nil {
+			return
+		} else if !errors.Is(err, os.ErrNotExist) {
+			log.Println(err)
+			return
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return
+		}
+		dir = parent
+	}
+}
 
-			if err := cmd.Run(); err != nil {
-				log.Printf("Failed to run: %v.\n", err.Error())
+// Watch all script directories for changes until `ctx` is cancelled.
+//
+// While watching, the list of scripts is cached and only refreshed after
+// changes. Scripts which are added (or made executable) for the current mode
+// run immediately. Directories which do not exist yet are picked up once they
+// are created.
+func (runner *ScriptRunner) Watch(ctx context.Context) error {
+	watcher, err := newDirWatcher(ctx)
+	if err != nil {
+		return err
+	}
+
+	var dirs []string
+	for _, mode := range []Mode{DARK, LIGHT} {
+		dirs = append(dirs, ScriptDirectories(mode, runner.scriptDirs)...)
+	}
+	watchAll := func() {
+		for _, dir := range dirs {
+			// The parent is also watched, in case the directory itself
+			// is removed and created again.
+			watchNearest(watcher, filepath.Dir(dir))
+			watchNearest(watcher, dir)
+		}
+	}
+	watchAll()
+
+	runner.mu.Lock()
+	runner.cache = make(map[Mode][]Script)
+	for _, mode := range []Mode{DARK, LIGHT} {
+		runner.cache[mode] = FindScripts(mode, runner.scriptDirs)
+	}
+	runner.mu.Unlock()
+
+	go func() {
+		var rescan <-chan time.Time
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case path := <-watcher.Events:
+				created := false
+				for _, dir := range dirs {
+					if isAncestor(path, dir) {
+						created = true
+						break
+					}
+				}
+				if created {
+					// A script directory, or one of its ancestors,
+					// may have been created.
+					watchAll()
+				} else if !affectsScripts(path) {
+					continue
+				}
+				if rescan == nil {
+					rescan = time.After(rescanDelay)
+				}
+			case <-rescan:
+				rescan = nil
+				runner.rescan()
 			}
 		}
 	}()
@@ -71,6 +354,305 @@ func RunScripts(mode Mode) error {
 	return nil
 }
 
+// Refresh the cache of scripts, and run any new scripts for the current mode.
+func (runner *ScriptRunner) rescan() {
+	runner.mu.Lock()
+	var added []Script
+	for _, mode := range []Mode{DARK, LIGHT} {
+		previous := runner.cache[mode]
+		runner.cache[mode] = FindScripts(mode, runner.scriptDirs)
+		if mode == runner.mode {
+			added = newlyEnabled(previous, runner.cache[mode])
+		}
+	}
+	mode, ctx := runner.mode, runner.ctx
+	runner.mu.Unlock()
+
+	if len(added) > 0 {
+		for _, script := range added {
+			log.Printf("Found new script %v.", script.Path)
+		}
+		go runner.execute(ctx, mode, added)
+	}
+}
+
+// Returns the environment for scripts which run for a given mode.
+//
+// This is darkman's own environment, plus $DARKMAN_MODE and the variables
+// defined for that mode in the configuration file.
+func ScriptEnvironment(vars map[string]string, mode Mode) []string {
+	env := append(os.Environ(), "DARKMAN_MODE="+string(mode))
+	names := make([]string, 0, len(vars))
+	for name := range vars {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		env = append(env, name+"="+vars[name])
+	}
+	return env
+}
+
+// Run a single script, waiting for it to finish.
+//
+// The output of the script is saved into a separate log file, and is also
+// relayed to the service's log.
+func runScript(mode Mode, script Script, env []string) error {
+	return runScriptCapturing(mode, script, env, scriptLogDir, nil)
+}
+
+// Like runScript, but the log file is created in `logDir` (see
+// createScriptLog), and the script's standard output is also written into
+// `capture`, unless nil.
+func runScriptCapturing(mode Mode, script Script, env []string, logDir string, capture io.Writer) error {
+	command := script.Command()
+	log.Printf("Running %v...", strings.Join(command, " "))
+
+	output := &prefixWriter{prefix: scriptLogPrefix(script.Name)}
+	cmd := exec.Command(expandHome(command[0]), command[1:]...)
+	cmd.Env = env
+	if script.Hook != nil {
+		cmd.Dir = expandHome(script.Hook.Dir)
+		for key, value := range script.Hook.Env {
+			cmd.Env = append(cmd.Env, key+"="+value)
+		}
+	}
+	cmd.Stdout = output
+	cmd.Stderr = output
+
+	logFile, err := createScriptLog(logDir, script.Name, mode)
+	if err != nil {
+		log.Printf("Could not create log file for %v: %v.\n", script.Name, err)
+	} else {
+		writer := io.MultiWriter(&limitedWriter{logFile, scriptLogMaxSize}, output)
+		cmd.Stdout = writer
+		cmd.Stderr = writer
+	}
+
+	if capture != nil {
+		// Stdout and stderr are now different writers, so each will be
+		// copied from a separate goroutine.
+		shared := &syncWriter{w: cmd.Stdout}
+		cmd.Stdout = io.MultiWriter(shared, capture)
+		cmd.Stderr = shared
+	}
+
+	if script.Timeout > 0 {
+		// Use a separate process group, so that any children are also
+		// killed when the timeout expires.
+		cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	}
+
+	if err = cmd.Start(); err == nil {
+		if script.Timeout > 0 {
+			pid := cmd.Process.Pid
+			timer := time.AfterFunc(script.Timeout, func() {
+				log.Printf("%v timed out after %v.\n", script.Name, script.Timeout)
+				_ = syscall.Kill(-pid, syscall.SIGKILL)
+			})
+			defer timer.Stop()
+		}
+		err = cmd.Wait()
+	}
+	output.Flush()
+	if logFile != nil {
+		logFile.Close()
+	}
+
+	if err != nil {
+		log.Printf("Failed to run %v: %v.\n", script.Name, err.Error())
+	}
+	return err
+}
+
+// Run each script in sequence, waiting for each one to finish.
+//
+// Returns the result of each script, in the same order as `scripts`. Once
+// `ctx` is cancelled, no further scripts are started, and the result for each
+// of them is the context's error.
+func ExecuteScripts(ctx context.Context, mode Mode, scripts []Script, env []string) []error {
+	scriptsRunning.Lock()
+	defer scriptsRunning.Unlock()
+
+	results := make([]error, len(scripts))
+	for i, script := range scripts {
+		if err := ctx.Err(); err != nil {
+			results[i] = err
+			continue
+		}
+		results[i] = runScript(mode, script, env)
+	}
+	return results
+}
+
+// Returns true if a script which failed with `err` should be retried.
+func (runner *ScriptRunner) isRetryable(script Script, err error) bool {
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && exitErr.ExitCode() == exitTempFail {
+		return true
+	}
+	if script.Hook != nil && script.Hook.Retry {
+		return true
+	}
+	for _, name := range runner.retry.Scripts {
+		if name == script.Name {
+			return true
+		}
+	}
+	return false
+}
+
+// Run scripts, and then retry any that failed transiently with exponential
+// backoff. Retries are abandoned when `ctx` is cancelled.
+func (runner *ScriptRunner) execute(ctx context.Context, mode Mode, scripts []Script) {
+	delay := runner.retry.Delay
+	for attempt := 0; ; attempt++ {
+		if ctx.Err() != nil {
+			log.Println("Mode has changed, abandoning retries.")
+			return
+		}
+
+		var retry []Script
+		for i, err := range ExecuteScripts(ctx, mode, scripts, ScriptEnvironment(runner.vars[mode], mode)) {
+			if ctx.Err() != nil && errors.Is(err, ctx.Err()) {
+				// Skipped; the mode changed while waiting to run.
+				continue
+			}
+			runner.results.Record(scripts[i].ResultKey(), mode, err)
+			if err != nil && runner.isRetryable(scripts[i], err) {
+				retry = append(retry, scripts[i])
+			}
+		}
+
+		if len(retry) == 0 {
+			return
+		}
+		if attempt >= runner.retry.Attempts {
+			for _, script := range retry {
+				log.Printf("Giving up on %v after %d retries.\n", script.Name, attempt)
+			}
+			return
+		}
+
+		log.Printf("Will retry %d script(s) in %v.\n", len(retry), delay)
+		select {
+		case <-ctx.Done():
+			log.Println("Mode has changed, abandoning retries.")
+			return
+		case <-time.After(delay):
+		}
+
+		delay *= 2
+		scripts = retry
+	}
+}
+
+// Run scripts for a given mode in the foreground, once, and record the outcome
+// of each one. Scripts are not retried.
+//
+// Outcomes are recorded separately from those of the service (see
+// manualResultKey), so that manual runs never cause the service to skip
+// scripts at start-up.
+//
+// Returns the result of each script, in the same order as `scripts`.
+func (runner *ScriptRunner) RunForeground(mode Mode, scripts []Script) []error {
+	errs := ExecuteScripts(context.Background(), mode, scripts, ScriptEnvironment(runner.vars[mode], mode))
+	for i, err := range errs {
+		runner.results.Record(manualResultKey(&scripts[i]), mode, err)
+	}
+	return errs
+}
+
+// Abandon any pending retries, and return a context for the next run.
+//
+// Must be called with `runner.mu` held.
+func (runner *ScriptRunner) restart() context.Context {
+	if runner.cancel != nil {
+		runner.cancel()
+	}
+	runner.ctx, runner.cancel = context.WithCancel(context.Background())
+	return runner.ctx
+}
+
+// Abandon any pending retries. Used when the runner is replaced by another.
+func (runner *ScriptRunner) Stop() {
+	runner.mu.Lock()
+	defer runner.mu.Unlock()
+	if runner.cancel != nil {
+		runner.cancel()
+	}
+}
+
+// Run transition scripts for a given mode.
+//
+// Fires up all scripts asyncrhonously and returns immediately. Pending retries
+// from any previous transition are abandoned.
+//
+// On the first call only, scripts which have already applied this mode are
+// skipped. This avoids running them again at start-up, when the mode is
+// usually the same as the last time that darkman ran. On later transitions,
+// all scripts run.
+func (runner *ScriptRunner) RunScripts(mode Mode) error {
+	runner.mu.Lock()
+	runner.mode = mode
+	ctx := runner.restart()
+	initial := !runner.started
+	runner.started = true
+	runner.mu.Unlock()
+
+	var pending []Script
+	for _, script := range runner.Scripts(mode) {
+		if initial && runner.results.Applied(script.ResultKey()) == mode {
+			log.Printf("%v has already applied %v mode, skipping.", script.Name, mode)
+			continue
+		}
+		log.Printf("Found %v.", strings.Join(script.Command(), " "))
+		pending = append(pending, script)
+	}
+
+	go runner.execute(ctx, mode, pending)
+
+	return nil
+}
+
+// Run scripts for the current mode again, even if they have already applied
+// it. If `name` is not empty, only the script with that name runs.
+//
+// Returns an error if no mode has been applied yet, or no script exists with
+// the given name. Otherwise, scripts run asynchronously.
+func (runner *ScriptRunner) Reapply(name string) error {
+	runner.mu.Lock()
+	mode := runner.mode
+	ctx := runner.ctx
+	if name == "" {
+		ctx = runner.restart()
+	}
+	runner.mu.Unlock()
+
+	if mode == NULL {
+		return fmt.Errorf("no mode has been applied yet")
+	}
+
+	scripts := runner.Scripts(mode)
+	if name != "" {
+		var matching []Script
+		for _, script := range scripts {
+			if script.Name == name {
+				matching = append(matching, script)
+			}
+		}
+		if len(matching) == 0 {
+			return fmt.Errorf("no script named %q for %v mode", name, mode)
+		}
+		scripts = matching
+	}
+
+	log.Printf("Re-applying %v mode for %d script(s).\n", mode, len(scripts))
+	go runner.execute(ctx, mode, scripts)
+
+	return nil
+}
+
 // Check if a file is executable
 func IsExecutable(file string) (bool, error) {
 	m, err := os.Stat(file)
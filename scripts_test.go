@@ -0,0 +1,301 @@
+package darkman
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/adrg/xdg"
+)
+
+func writeScript(t *testing.T, path string, executable bool) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal("failed to create script directory:", err)
+	}
+	perm := os.FileMode(0644)
+	if executable {
+		perm = 0755
+	}
+	if err := os.WriteFile(path, []byte("#!/bin/sh\n"), perm); err != nil {
+		t.Fatal("failed to write test script:", err)
+	}
+}
+
+func TestFindScriptsShadowing(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("XDG_DATA_DIRS", filepath.Join(dir, "system"))
+	t.Setenv("XDG_DATA_HOME", filepath.Join(dir, "home"))
+	t.Setenv("XDG_CONFIG_HOME", filepath.Join(dir, "config"))
+	xdg.Reload()
+	defer xdg.Reload()
+
+	writeScript(t, filepath.Join(dir, "system/dark-mode.d/a.sh"), true)
+	writeScript(t, filepath.Join(dir, "system/dark-mode.d/b.sh"), true)
+	writeScript(t, filepath.Join(dir, "system/dark-mode.d/c.sh"), true)
+	writeScript(t, filepath.Join(dir, "home/dark-mode.d/a.sh"), true)
+	writeScript(t, filepath.Join(dir, "home/dark-mode.d/b.sh"), false)
+	writeScript(t, filepath.Join(dir, "config/darkman/dark-mode.d/d.sh"), true)
+	writeScript(t, filepath.Join(dir, "extra/dark-mode.d/d.sh"), true)
+
+	scripts := FindScripts(DARK, []string{filepath.Join(dir, "extra")})
+	if len(scripts) != 4 {
+		t.Fatalf("want 4 scripts, got %d: %v", len(scripts), scripts)
+	}
+
+	a, b, c, d := scripts[0], scripts[1], scripts[2], scripts[3]
+	if a.Name != "a.sh" || a.Path != filepath.Join(dir, "home/dark-mode.d/a.sh") || !a.Executable {
+		t.Errorf("a.sh should be enabled from the home directory, got %v", a)
+	}
+	if len(a.Shadowed) != 1 || a.Shadowed[0].Path != filepath.Join(dir, "system/dark-mode.d/a.sh") {
+		t.Errorf("a.sh should shadow the system script, got %v", a.Shadowed)
+	}
+	if b.Executable || len(b.Shadowed) != 1 {
+		t.Errorf("b.sh should be disabled and mask the system script, got %v", b)
+	}
+	if !c.Executable || len(c.Shadowed) != 0 {
+		t.Errorf("c.sh should be enabled and shadow nothing, got %v", c)
+	}
+	if d.Path != filepath.Join(dir, "extra/dark-mode.d/d.sh") || len(d.Shadowed) != 1 {
+		t.Errorf("d.sh from extra dirs should shadow the one in the config dir, got %v", d)
+	}
+
+	enabled := EnabledScripts(DARK, nil)
+	if len(enabled) != 3 || enabled[0].Name != "a.sh" || enabled[1].Name != "c.sh" || enabled[2].Name != "d.sh" {
+		t.Errorf("want a.sh, c.sh and d.sh enabled, got %v", enabled)
+	}
+}
+
+func TestScriptRetries(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("XDG_STATE_HOME", filepath.Join(dir, "state"))
+	xdg.Reload()
+	defer xdg.Reload()
+
+	// Fails with EX_TEMPFAIL twice, and then succeeds.
+	counter := filepath.Join(dir, "counter")
+	path := filepath.Join(dir, "flaky.sh")
+	script := "#!/bin/sh\necho x >> " + counter + "\n[ $(wc -l < " + counter + ") -ge 3 ] || exit 75\n"
+	if err := os.WriteFile(path, []byte(script), 0755); err != nil {
+		t.Fatal("failed to write test script:", err)
+	}
+
+	runner := NewScriptRunner(&Config{Retry: RetryConfig{Attempts: 5, Delay: time.Millisecond}}, LoadResults())
+	runner.execute(context.Background(), DARK, []Script{{Name: "flaky.sh", Path: path}})
+
+	data, err := os.ReadFile(counter)
+	if err != nil {
+		t.Fatal("failed to read counter:", err)
+	}
+	if runs := strings.Count(string(data), "\n"); runs != 3 {
+		t.Errorf("want 3 runs, got %d", runs)
+	}
+	if applied := runner.results.Applied("script:flaky.sh"); applied != DARK {
+		t.Errorf("want flaky.sh to have applied dark mode, got %v", applied)
+	}
+
+	// Retries are abandoned once the context is cancelled.
+	os.Remove(counter)
+	runner.retry.Delay = 50 * time.Millisecond
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(10*time.Millisecond, cancel)
+	runner.execute(ctx, DARK, []Script{{Name: "flaky.sh", Path: path}})
+
+	data, err = os.ReadFile(counter)
+	if err != nil {
+		t.Fatal("failed to read counter:", err)
+	}
+	if runs := strings.Count(string(data), "\n"); runs != 1 {
+		t.Errorf("want 1 run after cancelling, got %d", runs)
+	}
+
+	// Nothing runs at all if the context is already cancelled.
+	os.Remove(counter)
+	runner.execute(ctx, DARK, []Script{{Name: "flaky.sh", Path: path}})
+	if _, err := os.Stat(counter); !os.IsNotExist(err) {
+		t.Errorf("want no runs with a cancelled context, got %v", err)
+	}
+}
+
+func TestRunForegroundRecordsResults(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("XDG_STATE_HOME", filepath.Join(dir, "state"))
+	xdg.Reload()
+	defer xdg.Reload()
+
+	path := filepath.Join(dir, "theme")
+	if err := os.WriteFile(path, []byte("#!/bin/sh\n"), 0755); err != nil {
+		t.Fatal("failed to write test script:", err)
+	}
+
+	// Simulates the service, which has loaded results before the manual run.
+	daemon := LoadResults()
+	daemon.Record("integration:sway-ipc", LIGHT, nil)
+
+	config := Config{Hooks: map[Mode][]Hook{DARK: {{Name: "theme", Command: []string{"false"}}}}}
+	scripts := []Script{{Name: "theme", Path: path, Executable: true}}
+	scripts = append(scripts, HookScripts(config.Hooks[DARK])...)
+	NewScriptRunner(&config, LoadResults()).RunForeground(DARK, scripts)
+
+	// Results from both processes are kept.
+	daemon.Record("integration:sway-ipc", DARK, nil)
+	results := LoadResults()
+	if applied := results.Applied("manual:script:theme"); applied != DARK {
+		t.Errorf("want the script to have applied dark mode, got %v", applied)
+	}
+	// Manual runs do not cause the service to skip the script.
+	if applied := results.Applied("script:theme"); applied != NULL {
+		t.Errorf("want the service's result unchanged, got %v", applied)
+	}
+	if result := results.All()["manual:hook:theme"]; result.Applied != NULL || result.LastError == "" {
+		t.Errorf("want the hook's failure recorded separately, got %v", result)
+	}
+	if applied := results.Applied("integration:sway-ipc"); applied != DARK {
+		t.Errorf("want sway to have applied dark mode, got %v", applied)
+	}
+}
+
+func TestWatchRunsNewScripts(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("XDG_DATA_DIRS", filepath.Join(dir, "system"))
+	t.Setenv("XDG_DATA_HOME", filepath.Join(dir, "home"))
+	t.Setenv("XDG_CONFIG_HOME", filepath.Join(dir, "config"))
+	t.Setenv("XDG_STATE_HOME", filepath.Join(dir, "state"))
+	xdg.Reload()
+	defer xdg.Reload()
+	for _, mode := range []string{"dark", "light"} {
+		if err := os.MkdirAll(filepath.Join(dir, "home", mode+"-mode.d"), 0755); err != nil {
+			t.Fatal("failed to create script directory:", err)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	runner := NewScriptRunner(&Config{}, LoadResults())
+	if err := runner.Watch(ctx); err != nil {
+		t.Fatal("failed to watch script directories:", err)
+	}
+	if err := runner.RunScripts(DARK); err != nil {
+		t.Fatal("failed to run scripts:", err)
+	}
+
+	// Each script records the mode it ran for. Scripts are moved into place,
+	// so that they are never seen half-written.
+	marker := filepath.Join(dir, "ran")
+	for _, mode := range []string{"light", "dark"} {
+		script := "#!/bin/sh\necho $DARKMAN_MODE " + mode + " >> " + marker + "\n"
+		tmp := filepath.Join(dir, mode+".sh")
+		if err := os.WriteFile(tmp, []byte(script), 0755); err != nil {
+			t.Fatal("failed to write test script:", err)
+		}
+		if err := os.Rename(tmp, filepath.Join(dir, "home", mode+"-mode.d", "new.sh")); err != nil {
+			t.Fatal("failed to move test script:", err)
+		}
+	}
+
+	var data []byte
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(50 * time.Millisecond) {
+		if data, _ = os.ReadFile(marker); len(data) > 0 {
+			break
+		}
+	}
+	// Give the script for the other mode a chance to run, if it wrongly would.
+	time.Sleep(rescanDelay)
+	data, _ = os.ReadFile(marker)
+	if string(data) != "dark dark\n" {
+		t.Errorf("want only the new dark mode script to run, got %q", data)
+	}
+}
+
+func TestRunScriptsSkipsAppliedOnStartup(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("XDG_DATA_DIRS", filepath.Join(dir, "system"))
+	t.Setenv("XDG_DATA_HOME", filepath.Join(dir, "home"))
+	t.Setenv("XDG_CONFIG_HOME", filepath.Join(dir, "config"))
+	t.Setenv("XDG_STATE_HOME", filepath.Join(dir, "state"))
+	xdg.Reload()
+	defer xdg.Reload()
+
+	marker := filepath.Join(dir, "ran")
+	path := filepath.Join(dir, "home/dark-mode.d/theme")
+	writeScript(t, path, true)
+	if err := os.WriteFile(path, []byte("#!/bin/sh\necho x >> "+marker+"\n"), 0755); err != nil {
+		t.Fatal("failed to write test script:", err)
+	}
+
+	results := LoadResults()
+	results.Record("script:theme", DARK, nil)
+	runner := NewScriptRunner(&Config{}, results)
+
+	// On start-up, the script has already applied dark mode.
+	if err := runner.RunScripts(DARK); err != nil {
+		t.Fatal("failed to run scripts:", err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if _, err := os.Stat(marker); !os.IsNotExist(err) {
+		t.Errorf("want no runs on start-up, got %v", err)
+	}
+
+	// Later transitions run all scripts, even if the mode is the same.
+	started := time.Now()
+	if err := runner.RunScripts(DARK); err != nil {
+		t.Fatal("failed to run scripts:", err)
+	}
+	// Wait until the outcome is recorded, which happens after the script exits.
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(50 * time.Millisecond) {
+		if results.All()["script:theme"].LastRun.After(started) {
+			break
+		}
+	}
+	data, _ := os.ReadFile(marker)
+	if string(data) != "x\n" {
+		t.Errorf("want the script to run once after start-up, got %q", data)
+	}
+}
+
+func TestWatchNewDirectories(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("XDG_DATA_DIRS", filepath.Join(dir, "system"))
+	t.Setenv("XDG_DATA_HOME", filepath.Join(dir, "home"))
+	t.Setenv("XDG_CONFIG_HOME", filepath.Join(dir, "config"))
+	t.Setenv("XDG_STATE_HOME", filepath.Join(dir, "state"))
+	xdg.Reload()
+	defer xdg.Reload()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	runner := NewScriptRunner(&Config{}, LoadResults())
+	if err := runner.Watch(ctx); err != nil {
+		t.Fatal("failed to watch script directories:", err)
+	}
+	if err := runner.RunScripts(DARK); err != nil {
+		t.Fatal("failed to run scripts:", err)
+	}
+
+	// None of the configuration directory exists when watching starts.
+	scriptDir := filepath.Join(dir, "config/darkman/dark-mode.d")
+	if err := os.MkdirAll(scriptDir, 0755); err != nil {
+		t.Fatal("failed to create script directory:", err)
+	}
+	marker := filepath.Join(dir, "ran")
+	tmp := filepath.Join(dir, "new.sh")
+	if err := os.WriteFile(tmp, []byte("#!/bin/sh\necho $DARKMAN_MODE >> "+marker+"\n"), 0755); err != nil {
+		t.Fatal("failed to write test script:", err)
+	}
+	if err := os.Rename(tmp, filepath.Join(scriptDir, "new.sh")); err != nil {
+		t.Fatal("failed to move test script:", err)
+	}
+
+	// Wait until the outcome is recorded, which happens after the script exits.
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(50 * time.Millisecond) {
+		if runner.results.Applied("script:new.sh") == DARK {
+			break
+		}
+	}
+	data, _ := os.ReadFile(marker)
+	if string(data) != "dark\n" {
+		t.Errorf("want the new script to run, got %q", data)
+	}
+}
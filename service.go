@@ -9,6 +9,10 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
 
 	"github.com/adrg/xdg"
 	"gitlab.com/WhyNotHugo/darkman/geoclue"
@@ -17,7 +21,16 @@ import (
 type Mode string
 type Service struct {
 	currentMode Mode
-	listeners   *[]func(Mode) error
+	listeners   []*listener
+	// Incremented with each transition which is committed. Used to skip
+	// notifying listeners of transitions which a newer one has replaced.
+	transitions uint64
+	// Called before automatic transitions; may veto or postpone them.
+	approve func(Mode) Verdict
+	// Incremented with each requested transition. Used to discard the
+	// outcome of approvals (or postponements) which are no longer relevant.
+	generation uint64
+	mu         sync.Mutex
 }
 
 const (
@@ -27,38 +40,207 @@ const (
 )
 
 // Creates a new Service instance.
-func NewService(initialMode Mode) Service {
-	return Service{
+func NewService(initialMode Mode) *Service {
+	return &Service{
 		currentMode: initialMode,
-		listeners:   &[]func(Mode) error{},
+		listeners:   []*listener{},
 	}
 }
 
 // Add a callback to be run each time the current mode changes.
-func (service *Service) AddListener(listener func(Mode) error) {
-	*service.listeners = append(*service.listeners, listener)
+func (service *Service) AddListener(callback func(Mode) error) {
+	service.mu.Lock()
+	service.listeners = append(service.listeners, &listener{notify: callback})
+	mode := service.currentMode
+	service.mu.Unlock()
+
 	// Apply once with the initial mode.
-	if err := listener(service.currentMode); err != nil {
+	if err := callback(mode); err != nil {
 		fmt.Println("error applying initial mode:", err)
 	}
 }
 
+// Set a callback which approves each automatic transition before it happens.
+func (service *Service) SetApprover(approve func(Mode) Verdict) {
+	service.mu.Lock()
+	defer service.mu.Unlock()
+	service.approve = approve
+}
+
 // Change the current mode (and run all callbacks).
+//
+// This is used for automatic transitions. If an approver has been set, it
+// runs asynchronously first, and may veto or postpone the transition.
 func (service *Service) ChangeMode(mode Mode) {
 	log.Printf("Wanted mode is: %v mode.\n", mode)
+
+	service.mu.Lock()
+	service.generation++
+	generation := service.generation
+	service.mu.Unlock()
+
+	service.requestMode(mode, generation)
+}
+
+// Change the current mode immediately, skipping any approval.
+//
+// This is used for changes explicitly requested by the user. Any postponed
+// transition is discarded.
+func (service *Service) OverrideMode(mode Mode) {
+	service.SetMode(mode, "")
+}
+
+// Like OverrideMode, but logs the reason for the change.
+func (service *Service) SetMode(mode Mode, reason string) {
+	if reason == "" {
+		log.Printf("Mode explicitly set to: %v mode.\n", mode)
+	} else {
+		log.Printf("Mode explicitly set to: %v mode (%v).\n", mode, reason)
+	}
+	service.override(func(Mode) Mode { return mode })
+}
+
+// Switch to the opposite of the current mode, and return the new mode.
+//
+// Reading the current mode and changing it happen atomically, so concurrent
+// changes are never lost.
+func (service *Service) Toggle() Mode {
+	mode := service.override(func(current Mode) Mode {
+		if current == LIGHT {
+			return DARK
+		}
+		return LIGHT
+	})
+	log.Printf("Mode toggled to: %v mode.\n", mode)
+	return mode
+}
+
+// Returns the current mode.
+func (service *Service) CurrentMode() Mode {
+	service.mu.Lock()
+	defer service.mu.Unlock()
+	return service.currentMode
+}
+
+// Change the current mode to the one returned by `choose`, which receives the
+// current mode. Any postponed transition is discarded.
+func (service *Service) override(choose func(Mode) Mode) Mode {
+	service.mu.Lock()
+	service.generation++
+	mode := choose(service.currentMode)
 	if mode == service.currentMode {
+		service.mu.Unlock()
+		log.Println("No transition necessary")
+		return mode
+	}
+	service.currentMode = mode
+	service.transitions++
+	listeners, transition := service.listeners, service.transitions
+	service.mu.Unlock()
+
+	service.notify(listeners, mode, transition)
+	return mode
+}
+
+// Seek approval for a transition, and then commit it.
+func (service *Service) requestMode(mode Mode, generation uint64) {
+	service.mu.Lock()
+	current, approve := service.currentMode, service.approve
+	stale := generation != service.generation
+	service.mu.Unlock()
+
+	if stale {
+		log.Printf("Discarding stale transition to %v mode.\n", mode)
+		return
+	}
+	if mode == current {
 		log.Println("No transition necessary")
 		return
 	}
+	if approve == nil {
+		service.commit(mode, generation)
+		return
+	}
 
-	log.Println("Notifying all transition handlers of new mode.")
-	service.currentMode = mode
-	for _, listener := range *service.listeners {
-		go func(listener func(Mode) error, mode Mode) {
-			if err := listener(mode); err != nil {
-				fmt.Println("Error notifying listener:", err)
+	go func() {
+		verdict := approve(mode)
+		if verdict.Veto {
+			log.Printf("Transition to %v mode has been vetoed.\n", mode)
+			return
+		}
+		if verdict.Postpone > 0 {
+			if service.isStale(generation) {
+				log.Printf("Discarding stale transition to %v mode.\n", mode)
+				return
 			}
-		}(listener, mode)
+			log.Printf("Transition to %v mode postponed for %v.\n", mode, verdict.Postpone)
+			time.AfterFunc(verdict.Postpone, func() {
+				service.requestMode(mode, generation)
+			})
+			return
+		}
+		service.commit(mode, generation)
+	}()
+}
+
+// Returns true if another transition has been requested since `generation`.
+func (service *Service) isStale(generation uint64) bool {
+	service.mu.Lock()
+	defer service.mu.Unlock()
+	return generation != service.generation
+}
+
+// Update the current mode and notify all listeners, unless another transition
+// has been requested since `generation`.
+func (service *Service) commit(mode Mode, generation uint64) {
+	service.mu.Lock()
+	if generation != service.generation {
+		service.mu.Unlock()
+		log.Printf("Discarding stale transition to %v mode.\n", mode)
+		return
+	}
+	if mode == service.currentMode {
+		service.mu.Unlock()
+		log.Println("No transition necessary")
+		return
+	}
+	service.currentMode = mode
+	service.transitions++
+	listeners, transition := service.listeners, service.transitions
+	service.mu.Unlock()
+
+	service.notify(listeners, mode, transition)
+}
+
+// A callback run each time the current mode changes.
+type listener struct {
+	notify func(Mode) error
+	// The last transition passed on to the callback.
+	transition uint64
+	// Held while the callback runs, so that it runs once at a time.
+	mu sync.Mutex
+}
+
+// Run the callback for a transition, unless it has already run for a newer
+// one. Callbacks for consecutive transitions may start in any order, so this
+// ensures that the last transition is always the last one applied.
+func (l *listener) run(mode Mode, transition uint64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if transition < l.transition {
+		return
+	}
+	l.transition = transition
+	if err := l.notify(mode); err != nil {
+		fmt.Println("Error notifying listener:", err)
+	}
+}
+
+// Run all listeners for a new mode.
+func (service *Service) notify(listeners []*listener, mode Mode, transition uint64) {
+	log.Println("Notifying all transition handlers of new mode.")
+	for _, l := range listeners {
+		go l.run(mode, transition)
 	}
 }
 
@@ -137,24 +319,36 @@ func GetInitialModeTime(configTime *Time) Mode {
 	}
 }
 
-// Run the darkman service.
-func ExecuteService(ctx context.Context, readyFd *os.File) error {
+// Run the darkman service. The version is only shown to D-Bus clients. With
+// `replace`, another running instance is replaced, and exits.
+//
+// Runs until `ctx` is cancelled, or another instance replaces this one.
+func ExecuteService(ctx context.Context, readyFd *os.File, version string, replace bool) error {
 	log.SetFlags(log.Lshortfile)
 
+	// Stops everything if another instance takes over.
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
 	config := Default()
 	if err := ReadConfig(&config); err != nil {
 		log.Println("Could not read configuration file:", err)
 	}
+	if err := config.Validate(); err != nil {
+		log.Println(err)
+	}
 
 	initialLocation := readLocationFromCache()
 	if initialLocation != nil {
-		log.Println("Read location from cache:", initialLocation)
+		log.Println("Read location from cache:", initialLocation.Location)
+	} else if location, _, err := config.GetLocation(); err != nil || location == nil {
+		log.Println("No location found via config.")
 	} else {
-		initialLocation, _, err := config.GetLocation()
-		if err != nil {
-			log.Println("No location found via config.")
-		} else {
-			log.Println("Found location in config:", initialLocation)
+		log.Println("Found location in config:", *location)
+		initialLocation = &LocationInfo{
+			Location:  *location,
+			Source:    LocationFromConfig,
+			Timestamp: time.Now(),
 		}
 	}
 
@@ -167,49 +361,59 @@ func ExecuteService(ctx context.Context, readyFd *os.File) error {
 
 	var initialMode Mode
 	if initialLocation != nil {
-		initialMode = GetInitialMode(initialLocation)
+		initialMode = GetInitialMode(&initialLocation.Location)
 	} else {
 		initialMode = GetInitialModeTime(initialTime)
 	}
 	log.Println("Initial mode set to:", initialMode)
 
 	service := NewService(initialMode)
-	service.AddListener(RunScripts)
-	service.AddListener(saveModeToCache)
-
-	if config.DBusServer {
-		log.Println("Running with D-Bus server.")
-		dbus, err := NewDbusServer(ctx, initialMode, service.ChangeMode)
-		if err != nil {
-			return err
+	scheduler := NewScheduler(initialLocation, initialTime, service.ChangeMode)
+	scheduler.AddLocationListener(func(location LocationInfo) {
+		// Locations from the configuration file are read again on startup.
+		if location.Source == LocationFromConfig {
+			return
 		}
-		service.AddListener(dbus.ChangeMode)
-	} else {
-		log.Println("Running without D-Bus server.")
-	}
-
-	if config.Portal {
-		log.Println("Running with XDG portal.")
-		portal, err := NewPortal(ctx, initialMode)
-		if err != nil {
-			return err
+		if err := saveLocationToCache(location); err != nil {
+			log.Println("Error saving location to cache:", err)
+		} else {
+			log.Println("Saved location to cache.")
 		}
-		service.AddListener(portal.ChangeMode)
-	} else {
-		log.Println("Running without XDG portal.")
+	})
+	daemon := NewDaemon(ctx, version, replace, config, service, scheduler, LoadResults())
+	if err := daemon.Start(); err != nil {
+		return err
 	}
+	service.AddListener(saveModeToCache)
 
-	if initialLocation != nil || initialTime != nil || config.UseGeoclue {
-		// Start after registering all callbacks, so that the first changes
-		// are triggered after they're all listening.
-		if err := NewScheduler(ctx, initialLocation, initialTime, service.ChangeMode, config.UseGeoclue); err != nil {
-			return fmt.Errorf("failed to initialise service scheduler: %v", err)
-		}
-	} else {
+	if initialLocation == nil && initialTime == nil && !config.UseGeoclue {
 		log.Println("Not using geoclue, no configured location and no configured time.")
-		log.Println("No automatic transitions will be scheduled.")
+		log.Println("No automatic transitions will be scheduled until a location is set.")
+	}
+	// Start after registering all callbacks, so that the first changes are
+	// triggered after they're all listening.
+	if err := scheduler.Start(ctx, config.UseGeoclue); err != nil {
+		return fmt.Errorf("failed to initialise service scheduler: %v", err)
 	}
 
+	// Reload the configuration on SIGHUP, as is customary for daemons.
+	hangups := make(chan os.Signal, 1)
+	signal.Notify(hangups, syscall.SIGHUP)
+	go func() {
+		defer signal.Stop(hangups)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-hangups:
+				log.Println("Received SIGHUP.")
+				if err := daemon.Reload(); err != nil {
+					log.Println(err)
+				}
+			}
+		}
+	}()
+
 	if readyFd != nil {
 		if _, err := readyFd.Write([]byte("\n")); err != nil {
 			return fmt.Errorf("error writing to ready-fd: %v", err)
@@ -219,8 +423,12 @@ func ExecuteService(ctx context.Context, readyFd *os.File) error {
 		}
 	}
 
-	// Run until explicitly stopped.
-	<-ctx.Done()
+	// Run until explicitly stopped, or replaced.
+	select {
+	case <-ctx.Done():
+	case <-daemon.Replaced():
+		log.Println("Replaced by another instance; exiting.")
+	}
 
 	return nil
 }
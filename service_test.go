@@ -0,0 +1,125 @@
+package darkman
+
+import (
+	"testing"
+	"time"
+)
+
+// Returns a listener which sends each mode into a channel, skipping the
+// initial one.
+func collectModes(service *Service) chan Mode {
+	modes := make(chan Mode, 10)
+	initial := true
+	service.AddListener(func(mode Mode) error {
+		if initial {
+			initial = false
+			return nil
+		}
+		modes <- mode
+		return nil
+	})
+	return modes
+}
+
+func expectMode(t *testing.T, modes chan Mode, want Mode) {
+	select {
+	case mode := <-modes:
+		if mode != want {
+			t.Errorf("want transition to %v, got %v", want, mode)
+		}
+	case <-time.After(time.Second):
+		t.Errorf("want transition to %v, got none", want)
+	}
+}
+
+func expectNoMode(t *testing.T, modes chan Mode) {
+	select {
+	case mode := <-modes:
+		t.Errorf("want no transition, got %v", mode)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestChangeModeVeto(t *testing.T) {
+	service := NewService(LIGHT)
+	modes := collectModes(service)
+	service.SetApprover(func(Mode) Verdict { return Verdict{Veto: true} })
+
+	service.ChangeMode(DARK)
+	expectNoMode(t, modes)
+
+	// Explicit changes skip approval.
+	service.OverrideMode(DARK)
+	expectMode(t, modes, DARK)
+}
+
+func TestChangeModePostpone(t *testing.T) {
+	service := NewService(LIGHT)
+	modes := collectModes(service)
+
+	calls := make(chan struct{}, 10)
+	service.SetApprover(func(Mode) Verdict {
+		calls <- struct{}{}
+		if len(calls) == 1 {
+			return Verdict{Postpone: 10 * time.Millisecond}
+		}
+		return Verdict{}
+	})
+
+	service.ChangeMode(DARK)
+	expectMode(t, modes, DARK)
+	if len(calls) != 2 {
+		t.Errorf("want approval to be requested twice, got %d", len(calls))
+	}
+
+	// A postponed transition is discarded if the mode is set explicitly, and
+	// approval is not requested again.
+	calls = make(chan struct{}, 10)
+	postponed := false
+	service.SetApprover(func(Mode) Verdict {
+		calls <- struct{}{}
+		if !postponed {
+			postponed = true
+			return Verdict{Postpone: 20 * time.Millisecond}
+		}
+		return Verdict{}
+	})
+	service.ChangeMode(LIGHT)
+	select {
+	case <-calls:
+	case <-time.After(time.Second):
+		t.Fatal("want approval to be requested, got none")
+	}
+	service.OverrideMode(LIGHT)
+	expectMode(t, modes, LIGHT)
+	service.OverrideMode(DARK)
+	expectMode(t, modes, DARK)
+
+	// Wait for the postponement to elapse a few times over.
+	time.Sleep(100 * time.Millisecond)
+	expectNoMode(t, modes)
+	if len(calls) != 0 {
+		t.Errorf("want no further approvals after override, got %d", len(calls))
+	}
+}
+
+func TestToggle(t *testing.T) {
+	service := NewService(NULL)
+	modes := collectModes(service)
+
+	if mode := service.Toggle(); mode != LIGHT {
+		t.Errorf("want light mode after toggling from none, got %v", mode)
+	}
+	expectMode(t, modes, LIGHT)
+	if mode := service.Toggle(); mode != DARK {
+		t.Errorf("want dark mode after toggling from light, got %v", mode)
+	}
+	expectMode(t, modes, DARK)
+
+	// A postponed transition is discarded when toggling.
+	service.SetApprover(func(Mode) Verdict { return Verdict{Postpone: 10 * time.Millisecond} })
+	service.ChangeMode(LIGHT)
+	service.Toggle()
+	expectMode(t, modes, LIGHT)
+	expectNoMode(t, modes)
+}
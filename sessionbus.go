@@ -0,0 +1,257 @@
+package darkman
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/godbus/dbus/v5"
+)
+
+// A connection to the session bus, which is only established when first used.
+//
+// This allows integrations which are not configured to never connect, and
+// re-connects if the connection is lost.
+type lazySessionBus struct {
+	ctx  context.Context
+	conn *dbus.Conn
+	mu   sync.Mutex
+}
+
+// Returns a connection to the session bus, connecting if necessary. The
+// connection is closed when `ctx` is cancelled.
+func (bus *lazySessionBus) Conn() (*dbus.Conn, error) {
+	bus.mu.Lock()
+	defer bus.mu.Unlock()
+
+	if bus.conn != nil && bus.conn.Connected() {
+		return bus.conn, nil
+	}
+	conn, err := dbus.ConnectSessionBus(dbus.WithContext(bus.ctx))
+	if err != nil {
+		return nil, fmt.Errorf("could not connect to session D-Bus: %v", err)
+	}
+	bus.conn = conn
+	return conn, nil
+}
+
+// Returned when another process owns a name, and does not give it up.
+var errNameTaken = errors.New("name already taken")
+
+// Owns a well-known name on the session bus, along with the objects exported
+// under it.
+//
+// If the connection is lost (e.g.: because the bus restarted), it reconnects
+// and exports everything again. Other instances may take over the name, in
+// which case Replaced is closed.
+type busName struct {
+	name string
+	// Whether to take over the name from another instance on start-up.
+	replace bool
+	// Exports all objects on a new connection.
+	export   func(conn *dbus.Conn) error
+	replaced chan struct{}
+}
+
+// Creates a new busName. `export` is called again with each new connection.
+func newBusName(name string, replace bool, export func(conn *dbus.Conn) error) *busName {
+	return &busName{
+		name:     name,
+		replace:  replace,
+		export:   export,
+		replaced: make(chan struct{}),
+	}
+}
+
+// Connect, export all objects and request the name, which is then kept until
+// `ctx` is cancelled.
+func (bus *busName) Start(ctx context.Context) error {
+	conn, signals, err := bus.connect(ctx, bus.replace)
+	if err == errNameTaken && !bus.replace {
+		return fmt.Errorf("can't register D-Bus name %v: %v (use --replace to take it over)", bus.name, err)
+	} else if err != nil {
+		return err
+	}
+	go bus.keep(ctx, conn, signals)
+	return nil
+}
+
+// Closed when another instance takes over the name.
+func (bus *busName) Replaced() <-chan struct{} {
+	return bus.replaced
+}
+
+func (bus *busName) connect(ctx context.Context, replace bool) (*dbus.Conn, chan *dbus.Signal, error) {
+	conn, err := dbus.ConnectSessionBus(dbus.WithContext(ctx))
+	if err != nil {
+		return nil, nil, fmt.Errorf("could not connect to session D-Bus: %v", err)
+	}
+	// The bus sends NameLost directly to its owner, so no match rule is needed.
+	signals := make(chan *dbus.Signal, 10)
+	conn.Signal(signals)
+
+	if err := bus.export(conn); err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+
+	// Always allow replacement, so that a newer instance can take over.
+	flags := dbus.NameFlagDoNotQueue | dbus.NameFlagAllowReplacement
+	if replace {
+		flags |= dbus.NameFlagReplaceExisting
+	}
+	reply, err := conn.RequestName(bus.name, flags)
+	if err != nil {
+		conn.Close()
+		return nil, nil, fmt.Errorf("failed to register dbus name: %v", err)
+	}
+	if reply != dbus.RequestNameReplyPrimaryOwner && reply != dbus.RequestNameReplyAlreadyOwner {
+		conn.Close()
+		return nil, nil, errNameTaken
+	}
+
+	log.Println("Listening on D-Bus:", bus.name)
+	return conn, signals, nil
+}
+
+// Watch for the name being lost, or the connection dropping, until `ctx` is
+// cancelled.
+func (bus *busName) keep(ctx context.Context, conn *dbus.Conn, signals chan *dbus.Signal) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case signal, ok := <-signals:
+			if !ok {
+				// The connection was closed; handled below.
+				signals = nil
+				continue
+			}
+			if signal.Name == "org.freedesktop.DBus.NameLost" && len(signal.Body) > 0 && signal.Body[0] == bus.name {
+				log.Printf("Another instance took over %v.\n", bus.name)
+				conn.Close()
+				close(bus.replaced)
+				return
+			}
+		case <-conn.Context().Done():
+			if ctx.Err() != nil {
+				return
+			}
+			log.Printf("Lost connection to the session bus; reconnecting %v.\n", bus.name)
+			if conn, signals = bus.reconnect(ctx); conn == nil {
+				return
+			}
+		}
+	}
+}
+
+// Keep trying to connect again, backing off up to a minute between attempts.
+// Returns nil if `ctx` is cancelled, or another instance has taken the name in
+// the meantime.
+func (bus *busName) reconnect(ctx context.Context) (*dbus.Conn, chan *dbus.Signal) {
+	var backoff reconnectBackoff
+	for {
+		if !backoff.wait(ctx) {
+			return nil, nil
+		}
+
+		// Never take the name from an instance which started meanwhile.
+		conn, signals, err := bus.connect(ctx, false)
+		if err == nil {
+			return conn, signals
+		}
+		if err == errNameTaken {
+			log.Printf("Another instance owns %v.\n", bus.name)
+			close(bus.replaced)
+			return nil, nil
+		}
+		log.Printf("Could not reconnect %v: %v\n", bus.name, err)
+	}
+}
+
+// Delays between attempts to reconnect to the session bus. Starts at a second,
+// and doubles with each attempt up to a minute.
+type reconnectBackoff struct {
+	delay time.Duration
+}
+
+// Wait until the next attempt. Returns false if `ctx` is cancelled first.
+func (backoff *reconnectBackoff) wait(ctx context.Context) bool {
+	if backoff.delay == 0 {
+		backoff.delay = time.Second
+	} else if backoff.delay < time.Minute {
+		backoff.delay *= 2
+	}
+	select {
+	case <-ctx.Done():
+		return false
+	case <-time.After(backoff.delay):
+		return true
+	}
+}
+
+// Connect to the session bus, and pass each signal received to `handle` until
+// `ctx` is cancelled. Signals are handled one at a time.
+//
+// `subscribe` is called with each new connection to add match rules (or
+// otherwise set it up). If the connection is lost (e.g.: because the bus
+// restarted), it reconnects and subscribes again. `what` is only logged.
+//
+// Returns an error if the first connection cannot be set up.
+func followSignals(ctx context.Context, what string, subscribe func(conn *dbus.Conn) error, handle func(signal *dbus.Signal)) error {
+	conn, signals, err := connectSignals(ctx, subscribe)
+	if err != nil {
+		return err
+	}
+
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case signal, ok := <-signals:
+				if !ok {
+					// The connection was closed; handled below.
+					signals = nil
+					continue
+				}
+				handle(signal)
+			case <-conn.Context().Done():
+				if ctx.Err() != nil {
+					return
+				}
+				log.Printf("Lost connection to the session bus; reconnecting %v.\n", what)
+				var backoff reconnectBackoff
+				for {
+					if !backoff.wait(ctx) {
+						return
+					}
+					if conn, signals, err = connectSignals(ctx, subscribe); err == nil {
+						break
+					}
+					log.Printf("Could not reconnect %v: %v\n", what, err)
+				}
+			}
+		}
+	}()
+
+	return nil
+}
+
+func connectSignals(ctx context.Context, subscribe func(conn *dbus.Conn) error) (*dbus.Conn, chan *dbus.Signal, error) {
+	conn, err := dbus.ConnectSessionBus(dbus.WithContext(ctx))
+	if err != nil {
+		return nil, nil, fmt.Errorf("could not connect to session D-Bus: %v", err)
+	}
+	signals := make(chan *dbus.Signal, 10)
+	conn.Signal(signals)
+
+	if err := subscribe(conn); err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+	return conn, signals, nil
+}
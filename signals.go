@@ -0,0 +1,189 @@
+package darkman
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// Length limit for process names in /proc/<pid>/comm, including the trailing
+// null byte.
+const taskCommLen = 16
+
+// Signals which may be sent to processes, by name.
+var signalNames = map[string]syscall.Signal{
+	"HUP":   syscall.SIGHUP,
+	"INT":   syscall.SIGINT,
+	"QUIT":  syscall.SIGQUIT,
+	"USR1":  syscall.SIGUSR1,
+	"USR2":  syscall.SIGUSR2,
+	"ALRM":  syscall.SIGALRM,
+	"TERM":  syscall.SIGTERM,
+	"CONT":  syscall.SIGCONT,
+	"WINCH": syscall.SIGWINCH,
+}
+
+// Real-time signals, which applications may use for anything, and which can
+// only be sent by number.
+const (
+	sigRtMin = 34
+	sigRtMax = 64
+)
+
+// Parse a signal name (e.g.: "SIGUSR1" or "USR1") or number.
+//
+// Only the signals in signalNames and real-time signals are allowed, whether
+// given by name or by number. Others (e.g.: SIGKILL or SIGSTOP) cannot be
+// handled by applications, so would only kill or stop them.
+func ParseSignal(name string) (syscall.Signal, error) {
+	if signal, ok := signalNames[strings.TrimPrefix(strings.ToUpper(name), "SIG")]; ok {
+		return signal, nil
+	}
+	number, err := strconv.Atoi(name)
+	if err != nil {
+		return 0, fmt.Errorf("unknown signal %q", name)
+	}
+	if number >= sigRtMin && number <= sigRtMax {
+		return syscall.Signal(number), nil
+	}
+	for _, signal := range signalNames {
+		if int(signal) == number {
+			return signal, nil
+		}
+	}
+	return 0, fmt.Errorf("signal %q is not allowed", name)
+}
+
+// Returns the name of the signal for the given mode.
+func (signal *Signal) ForMode(mode Mode) string {
+	switch mode {
+	case DARK:
+		return signal.Dark
+	case LIGHT:
+		return signal.Light
+	default:
+		return ""
+	}
+}
+
+// Returns a description of which processes receive this signal.
+func (signal *Signal) Target() string {
+	if signal.Exe != "" {
+		return signal.Exe
+	}
+	return signal.Name
+}
+
+// Returns true if the process with name `comm` and executable `exe` matches.
+//
+// Process names are truncated by the kernel, so longer names only need to
+// match up to that length.
+func (signal *Signal) matches(comm string, exe string) bool {
+	if signal.Exe != "" {
+		return strings.TrimSuffix(exe, " (deleted)") == expandHome(signal.Exe)
+	}
+	if len(signal.Name) >= taskCommLen-1 {
+		return comm == signal.Name[:taskCommLen-1]
+	}
+	return comm == signal.Name
+}
+
+// Returns the IDs of all processes owned by the current user which match
+// `signal`, excluding darkman itself.
+func findProcesses(procDir string, signal Signal) ([]int, error) {
+	entries, err := os.ReadDir(procDir)
+	if err != nil {
+		return nil, err
+	}
+
+	uid := uint32(os.Getuid())
+	self := os.Getpid()
+
+	var pids []int
+	for _, entry := range entries {
+		pid, err := strconv.Atoi(entry.Name())
+		if err != nil || pid == self {
+			continue
+		}
+		dir := filepath.Join(procDir, entry.Name())
+
+		// Processes may exit at any time; skip them if they do.
+		info, err := os.Stat(dir)
+		if err != nil {
+			continue
+		}
+		if stat, ok := info.Sys().(*syscall.Stat_t); !ok || stat.Uid != uid {
+			continue
+		}
+		comm, err := os.ReadFile(filepath.Join(dir, "comm"))
+		if err != nil {
+			continue
+		}
+		// Reading the executable fails for kernel threads; that's fine.
+		exe, _ := os.Readlink(filepath.Join(dir, "exe"))
+
+		if signal.matches(strings.TrimSuffix(string(comm), "\n"), exe) {
+			pids = append(pids, pid)
+		}
+	}
+	return pids, nil
+}
+
+// Sends signals to running processes on each transition, so that they reload
+// their configuration or theme.
+type ProcessSignaller struct {
+	signals []Signal
+	procDir string
+}
+
+// Creates a new ProcessSignaller for the signals in `config`.
+func NewProcessSignaller(config *Config) *ProcessSignaller {
+	return &ProcessSignaller{
+		signals: config.Signals,
+		procDir: "/proc",
+	}
+}
+
+// Send the signal configured for `mode` to all matching processes.
+//
+// Having no matching processes is not an error, since applications need not
+// be running.
+func (signaller *ProcessSignaller) ChangeMode(mode Mode) error {
+	if mode == NULL {
+		return nil
+	}
+
+	var failures []string
+	for _, signal := range signaller.signals {
+		name := signal.ForMode(mode)
+		if name == "" {
+			continue
+		}
+		sig, err := ParseSignal(name)
+		if err != nil {
+			failures = append(failures, fmt.Sprintf("%v: %v", signal.Target(), err))
+			continue
+		}
+
+		pids, err := findProcesses(signaller.procDir, signal)
+		if err != nil {
+			return fmt.Errorf("failed to list processes: %v", err)
+		}
+		for _, pid := range pids {
+			if err := syscall.Kill(pid, sig); err != nil && err != syscall.ESRCH {
+				failures = append(failures, fmt.Sprintf("%v (%d): %v", signal.Target(), pid, err))
+				continue
+			}
+			log.Printf("Sent %v to %v (%d).\n", name, signal.Target(), pid)
+		}
+	}
+
+	if len(failures) > 0 {
+		return fmt.Errorf("failed to signal processes:\n  %v", strings.Join(failures, "\n  "))
+	}
+	return nil
+}
@@ -0,0 +1,111 @@
+package darkman
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// Start a copy of sleep(1) with a distinctive name, which is longer than the
+// kernel's limit for process names.
+func startSleeper(t *testing.T, dir string) *exec.Cmd {
+	sleep, err := exec.LookPath("sleep")
+	if err != nil {
+		t.Skip("sleep is not available:", err)
+	}
+	data, err := os.ReadFile(sleep)
+	if err != nil {
+		t.Fatal("failed to read sleep:", err)
+	}
+	path := filepath.Join(dir, "darkman-test-sleeper")
+	if err := os.WriteFile(path, data, 0755); err != nil {
+		t.Fatal("failed to copy sleep:", err)
+	}
+
+	cmd := exec.Command(path, "30")
+	if err := cmd.Start(); err != nil {
+		t.Fatal("failed to start process:", err)
+	}
+	t.Cleanup(func() { cmd.Process.Kill() })
+	return cmd
+}
+
+// Returns the signal which terminated a process, or zero if it was not
+// terminated by one within a few seconds.
+func waitForSignal(cmd *exec.Cmd) syscall.Signal {
+	done := make(chan error, 1)
+	go func() { done <- cmd.Wait() }()
+
+	select {
+	case err := <-done:
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			if status, ok := exitErr.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+				return status.Signal()
+			}
+		}
+	case <-time.After(5 * time.Second):
+	}
+	return 0
+}
+
+func TestProcessSignaller(t *testing.T) {
+	dir := t.TempDir()
+
+	for _, signal := range []Signal{
+		{Name: "darkman-test-sleeper", Dark: "SIGUSR1"},
+		{Exe: filepath.Join(dir, "darkman-test-sleeper"), Dark: "USR1"},
+	} {
+		cmd := startSleeper(t, dir)
+		// Wait for the process to replace its image.
+		time.Sleep(100 * time.Millisecond)
+
+		signaller := NewProcessSignaller(&Config{Signals: []Signal{signal}})
+		if err := signaller.ChangeMode(LIGHT); err != nil {
+			t.Errorf("%v: failed to apply light mode: %v", signal.Target(), err)
+		}
+		if err := signaller.ChangeMode(DARK); err != nil {
+			t.Errorf("%v: failed to apply dark mode: %v", signal.Target(), err)
+		}
+
+		if sig := waitForSignal(cmd); sig != syscall.SIGUSR1 {
+			t.Errorf("%v: want process terminated by SIGUSR1, got %v", signal.Target(), sig)
+		}
+	}
+}
+
+func TestParseSignal(t *testing.T) {
+	cases := []struct {
+		name string
+		want syscall.Signal
+		ok   bool
+	}{
+		{"SIGUSR1", syscall.SIGUSR1, true},
+		{"usr2", syscall.SIGUSR2, true},
+		{"10", syscall.SIGUSR1, true},
+		{"1", syscall.SIGHUP, true},
+		{"34", syscall.Signal(34), true},
+		{"64", syscall.Signal(64), true},
+		{"KILL", 0, false},
+		{"SIGSTOP", 0, false},
+		{"9", 0, false},
+		{"19", 0, false},
+		{"0", 0, false},
+		{"65", 0, false},
+		{"-1", 0, false},
+		{"bogus", 0, false},
+	}
+
+	for _, c := range cases {
+		got, err := ParseSignal(c.name)
+		if c.ok && (err != nil || got != c.want) {
+			t.Errorf("%v: want %v, got %v (%v)", c.name, c.want, got, err)
+		} else if !c.ok && err == nil {
+			t.Errorf("%v: want an error, got %v", c.name, got)
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package darkman
+
+import (
+	"fmt"
+	"log"
+	"strings"
+
+	"gitlab.com/WhyNotHugo/darkman/swayipc"
+)
+
+// Name under which the outcome of sway commands is recorded in Results.
+const swayResultName = "integration:sway-ipc"
+
+// Returns the commands for the given mode.
+func (config *SwayConfig) ForMode(mode Mode) []string {
+	switch mode {
+	case DARK:
+		return config.Dark
+	case LIGHT:
+		return config.Light
+	default:
+		return nil
+	}
+}
+
+// Runs commands in sway (or i3) via its IPC socket on each transition.
+type SwayCommander struct {
+	config  SwayConfig
+	results *Results
+}
+
+// Creates a new SwayCommander using the settings in `config`. The outcome of
+// each transition is recorded in `results`, just like for scripts.
+func NewSwayCommander(config *Config, results *Results) *SwayCommander {
+	return &SwayCommander{
+		config:  config.Sway,
+		results: results,
+	}
+}
+
+// Run all commands for `mode`. Each command runs even if previous ones failed.
+func (commander *SwayCommander) run(mode Mode, commands []string) error {
+	socket := expandHome(commander.config.Socket)
+	if socket == "" {
+		socket = swayipc.SocketPath()
+	}
+	if socket == "" {
+		return fmt.Errorf("neither SWAYSOCK nor I3SOCK are set")
+	}
+
+	conn, err := swayipc.Dial(socket, commander.config.Timeout)
+	if err != nil {
+		return fmt.Errorf("failed to connect to %v: %v", socket, err)
+	}
+	defer conn.Close()
+
+	var failures []string
+	for _, command := range commands {
+		if err := conn.Run(command); err != nil {
+			failures = append(failures, err.Error())
+		}
+	}
+	if len(failures) > 0 {
+		return fmt.Errorf("%v", strings.Join(failures, "\n"))
+	}
+	return nil
+}
+
+// Run the commands for `mode`, and record the outcome.
+func (commander *SwayCommander) ChangeMode(mode Mode) error {
+	commands := commander.config.ForMode(mode)
+	if len(commands) == 0 {
+		return nil
+	}
+
+	err := commander.run(mode, commands)
+	if commander.results != nil {
+		commander.results.Record(swayResultName, mode, err)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to run sway commands: %v", err)
+	}
+
+	log.Printf("Ran %d sway command(s) for %v mode.\n", len(commands), mode)
+	return nil
+}
@@ -0,0 +1,75 @@
+package darkman
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/adrg/xdg"
+)
+
+// Reply to each command with `reply`, as sway would.
+func fakeSway(t *testing.T, path string, reply string) {
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal("failed to listen:", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			header := make([]byte, 14)
+			for {
+				if _, err := io.ReadFull(conn, header); err != nil {
+					break
+				}
+				io.CopyN(io.Discard, conn, int64(binary.LittleEndian.Uint32(header[6:])))
+				binary.LittleEndian.PutUint32(header[6:], uint32(len(reply)))
+				conn.Write(append(header, reply...))
+			}
+			conn.Close()
+		}
+	}()
+}
+
+func TestSwayCommanderResults(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("XDG_STATE_HOME", filepath.Join(dir, "state"))
+	xdg.Reload()
+	defer xdg.Reload()
+
+	good := filepath.Join(dir, "good.sock")
+	bad := filepath.Join(dir, "bad.sock")
+	fakeSway(t, good, `[{"success": true}]`)
+	fakeSway(t, bad, `[{"success": false, "error": "Unknown command"}]`)
+
+	results := LoadResults()
+	config := Config{Sway: SwayConfig{
+		Socket:  good,
+		Dark:    []string{"output * bg ~/dark.png fill"},
+		Timeout: time.Second,
+	}}
+	if err := NewSwayCommander(&config, results).ChangeMode(DARK); err != nil {
+		t.Fatal("failed to run commands:", err)
+	}
+	if applied := results.Applied(swayResultName); applied != DARK {
+		t.Errorf("want dark mode recorded as applied, got %v", applied)
+	}
+
+	config.Sway.Socket = bad
+	if err := NewSwayCommander(&config, results).ChangeMode(DARK); err == nil {
+		t.Error("want an error for a failed command")
+	}
+	result := results.All()[swayResultName]
+	if !strings.Contains(result.LastError, "Unknown command") {
+		t.Errorf("want the failure recorded, got %q", result.LastError)
+	}
+}
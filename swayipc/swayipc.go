@@ -0,0 +1,145 @@
+// Package swayipc implements a minimal client for the IPC protocol of sway and
+// i3.
+//
+// See: https://i3wm.org/docs/ipc.html
+package swayipc
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"time"
+)
+
+// Every message starts with this string.
+const magic = "i3-ipc"
+
+// Message type used to run commands.
+const runCommand = 0
+
+// Size of a message's header: the magic string, its length, and its type.
+const headerSize = len(magic) + 8
+
+// Largest payload accepted in a reply. Replies to commands are tiny, so anything
+// larger than this is a misbehaving peer.
+const maxPayload = 1 << 20
+
+// The outcome of a single command.
+type CommandResult struct {
+	Success    bool   `json:"success"`
+	ParseError bool   `json:"parse_error"`
+	Error      string `json:"error"`
+}
+
+// Returns the path to the IPC socket, as exported by sway or i3. Returns an
+// empty string if neither is running.
+func SocketPath() string {
+	if path := os.Getenv("SWAYSOCK"); path != "" {
+		return path
+	}
+	return os.Getenv("I3SOCK")
+}
+
+// A connection to sway or i3.
+type Conn struct {
+	conn    net.Conn
+	timeout time.Duration
+}
+
+// Connect to the IPC socket at `path`. Each command sent on the returned
+// connection must complete within `timeout`.
+func Dial(path string, timeout time.Duration) (*Conn, error) {
+	conn, err := net.DialTimeout("unix", path, timeout)
+	if err != nil {
+		return nil, err
+	}
+	return &Conn{conn: conn, timeout: timeout}, nil
+}
+
+// Close the connection.
+func (conn *Conn) Close() error {
+	return conn.conn.Close()
+}
+
+// Encode a message with a given type and payload.
+func encodeMessage(kind uint32, payload []byte) []byte {
+	message := make([]byte, headerSize+len(payload))
+	copy(message, magic)
+	binary.LittleEndian.PutUint32(message[len(magic):], uint32(len(payload)))
+	binary.LittleEndian.PutUint32(message[len(magic)+4:], kind)
+	copy(message[headerSize:], payload)
+	return message
+}
+
+// Read a single message, and return its type and payload.
+func readMessage(r io.Reader) (uint32, []byte, error) {
+	header := make([]byte, headerSize)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return 0, nil, err
+	}
+	if string(header[:len(magic)]) != magic {
+		return 0, nil, fmt.Errorf("invalid message header: %q", header)
+	}
+
+	length := binary.LittleEndian.Uint32(header[len(magic):])
+	kind := binary.LittleEndian.Uint32(header[len(magic)+4:])
+	if length > maxPayload {
+		return 0, nil, fmt.Errorf("message too large: %d bytes", length)
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return 0, nil, err
+	}
+	return kind, payload, nil
+}
+
+// Run a command, and return its result.
+//
+// A single message may contain several commands separated by semicolons, in
+// which case there is one result for each.
+func (conn *Conn) RunCommand(command string) ([]CommandResult, error) {
+	if err := conn.conn.SetDeadline(time.Now().Add(conn.timeout)); err != nil {
+		return nil, err
+	}
+	if _, err := conn.conn.Write(encodeMessage(runCommand, []byte(command))); err != nil {
+		return nil, fmt.Errorf("failed to send command: %v", err)
+	}
+
+	kind, payload, err := readMessage(conn.conn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read reply: %v", err)
+	}
+	if kind != runCommand {
+		return nil, fmt.Errorf("unexpected reply of type %d", kind)
+	}
+
+	var results []CommandResult
+	if err := json.Unmarshal(payload, &results); err != nil {
+		return nil, fmt.Errorf("invalid reply: %v", err)
+	}
+	return results, nil
+}
+
+// Run a command, and return an error if it (or any of the commands in it)
+// failed.
+func (conn *Conn) Run(command string) error {
+	results, err := conn.RunCommand(command)
+	if err != nil {
+		return err
+	}
+
+	var failures []string
+	for _, result := range results {
+		if !result.Success {
+			failures = append(failures, result.Error)
+		}
+	}
+	if len(failures) > 0 {
+		return fmt.Errorf("%q failed: %v", command, strings.Join(failures, "; "))
+	}
+	return nil
+}
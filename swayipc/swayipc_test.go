@@ -0,0 +1,81 @@
+package swayipc
+
+import (
+	"bytes"
+	"net"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEncodeMessage(t *testing.T) {
+	want := []byte("i3-ipc\x04\x00\x00\x00\x00\x00\x00\x00exit")
+	if got := encodeMessage(runCommand, []byte("exit")); !bytes.Equal(got, want) {
+		t.Errorf("want %q, got %q", want, got)
+	}
+
+	kind, payload, err := readMessage(bytes.NewReader(want))
+	if err != nil || kind != runCommand || string(payload) != "exit" {
+		t.Errorf("failed to read back message: %v %q %v", kind, payload, err)
+	}
+	if _, _, err := readMessage(strings.NewReader("i4-ipc\x00\x00\x00\x00\x00\x00\x00\x00")); err == nil {
+		t.Error("want an error for an invalid header")
+	}
+	if _, _, err := readMessage(strings.NewReader("i3-ipc\xff\xff\xff\xff\x00\x00\x00\x00")); err == nil || !strings.Contains(err.Error(), "too large") {
+		t.Errorf("want an error for an oversized message, got %v", err)
+	}
+}
+
+func TestRun(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sway-ipc.sock")
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal("failed to listen:", err)
+	}
+	defer listener.Close()
+
+	received := make(chan string, 3)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		for {
+			_, payload, err := readMessage(conn)
+			if err != nil {
+				return
+			}
+			received <- string(payload)
+			reply := `[{"success": true}]`
+			if strings.HasPrefix(string(payload), "bogus") {
+				reply = `[{"success": false, "parse_error": true, "error": "Unknown command 'bogus'"}]`
+			}
+			conn.Write(encodeMessage(runCommand, []byte(reply)))
+		}
+	}()
+
+	conn, err := Dial(path, time.Second)
+	if err != nil {
+		t.Fatal("failed to connect:", err)
+	}
+	defer conn.Close()
+
+	if err := conn.Run("output * bg ~/dark.png fill"); err != nil {
+		t.Errorf("command failed: %v", err)
+	}
+	results, err := conn.RunCommand("bogus")
+	want := []CommandResult{{Success: false, ParseError: true, Error: "Unknown command 'bogus'"}}
+	if err != nil || !reflect.DeepEqual(results, want) {
+		t.Errorf("want %v, got %v (%v)", want, results, err)
+	}
+	if err := conn.Run("bogus"); err == nil || !strings.Contains(err.Error(), "Unknown command") {
+		t.Errorf("want an error for a failed command, got %v", err)
+	}
+
+	if got := <-received; got != "output * bg ~/dark.png fill" {
+		t.Errorf("server received unexpected command: %q", got)
+	}
+}
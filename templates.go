@@ -0,0 +1,175 @@
+package darkman
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+)
+
+// Data available to templates when they are rendered.
+type TemplateData struct {
+	// The mode being applied.
+	Mode Mode
+	// Variables defined for this mode in the configuration file.
+	Vars map[string]string
+	// Time of the next sunrise and sunset. Zero if unknown.
+	Sunrise time.Time
+	Sunset  time.Time
+}
+
+// Renders configuration files from templates on each transition.
+type TemplateRenderer struct {
+	templates []Template
+	vars      map[Mode]map[string]string
+	sunTimes  func() (time.Time, time.Time)
+	// Held while rendering, since listeners for consecutive transitions may
+	// run concurrently.
+	mu sync.Mutex
+}
+
+// Creates a new TemplateRenderer for the templates in `config`. The sun times
+// exposed to templates are obtained via `sunTimes`, which may be nil.
+func NewTemplateRenderer(config *Config, sunTimes func() (sunrise time.Time, sunset time.Time)) *TemplateRenderer {
+	return &TemplateRenderer{
+		templates: config.Templates,
+		vars:      config.Vars,
+		sunTimes:  sunTimes,
+	}
+}
+
+// Parse a template from a file. Referencing undefined variables is an error.
+func parseTemplate(source string) (*template.Template, error) {
+	data, err := os.ReadFile(expandHome(source))
+	if err != nil {
+		return nil, err
+	}
+	return template.New(filepath.Base(source)).Option("missingkey=error").Parse(string(data))
+}
+
+// Render a single template into its output file.
+func renderTemplate(tmpl Template, data TemplateData) error {
+	parsed, err := parseTemplate(tmpl.Source)
+	if err != nil {
+		return fmt.Errorf("failed to parse template %v: %v", tmpl.Source, err)
+	}
+
+	var output bytes.Buffer
+	if err := parsed.Execute(&output, data); err != nil {
+		return fmt.Errorf("failed to render template %v: %v", tmpl.Source, err)
+	}
+
+	if err := writeFileAtomic(expandHome(tmpl.Output), output.Bytes(), 0644); err != nil {
+		return fmt.Errorf("failed to write %v: %v", tmpl.Output, err)
+	}
+	return nil
+}
+
+// Render all templates for `mode`.
+//
+// Templates are re-read each time, so changes to them apply on the next
+// transition. A failure to render one template does not prevent rendering the
+// others.
+func (renderer *TemplateRenderer) ChangeMode(mode Mode) error {
+	if mode == NULL || len(renderer.templates) == 0 {
+		return nil
+	}
+	renderer.mu.Lock()
+	defer renderer.mu.Unlock()
+
+	data := TemplateData{Mode: mode, Vars: renderer.vars[mode]}
+	if data.Vars == nil {
+		data.Vars = map[string]string{}
+	}
+	if renderer.sunTimes != nil {
+		data.Sunrise, data.Sunset = renderer.sunTimes()
+	}
+
+	var failures []string
+	for _, tmpl := range renderer.templates {
+		if err := renderTemplate(tmpl, data); err != nil {
+			log.Println(err)
+			failures = append(failures, err.Error())
+			continue
+		}
+		log.Printf("Rendered %v for %v mode.\n", tmpl.Output, mode)
+	}
+
+	if len(failures) > 0 {
+		return fmt.Errorf("failed to render %d template(s):\n  %v", len(failures), strings.Join(failures, "\n  "))
+	}
+	return nil
+}
+
+// Returns the file that `path` ultimately refers to, following any symlinks.
+// Unlike filepath.EvalSymlinks, the final target need not exist.
+func resolveSymlinks(path string) (string, error) {
+	for i := 0; i < 40; i++ {
+		info, err := os.Lstat(path)
+		if os.IsNotExist(err) {
+			return path, nil
+		} else if err != nil {
+			return "", err
+		}
+		if info.Mode()&os.ModeSymlink == 0 {
+			return path, nil
+		}
+
+		target, err := os.Readlink(path)
+		if err != nil {
+			return "", err
+		}
+		if !filepath.IsAbs(target) {
+			target = filepath.Join(filepath.Dir(path), target)
+		}
+		path = target
+	}
+	return "", fmt.Errorf("too many levels of symbolic links")
+}
+
+// Write a file atomically, by writing to a temporary file in the same
+// directory and renaming it over the destination.
+//
+// Readers never observe a partially written file. If the destination already
+// exists, its permissions are preserved; otherwise `perm` is used. If the
+// destination is a symlink, the file that it points to is replaced instead, so
+// that the symlink itself is kept.
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	path, err := resolveSymlinks(path)
+	if err != nil {
+		return err
+	}
+
+	if info, err := os.Stat(path); err == nil {
+		perm = info.Mode().Perm()
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(perm); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
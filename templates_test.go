@@ -0,0 +1,95 @@
+package darkman
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRenderTemplates(t *testing.T) {
+	dir := t.TempDir()
+	source := filepath.Join(dir, "colors.tmpl")
+	output := filepath.Join(dir, "colors.conf")
+	broken := filepath.Join(dir, "broken.tmpl")
+
+	tmpl := "mode={{.Mode}}\ntheme={{.Vars.THEME}}\nsunset={{.Sunset.Format \"15:04\"}}\n"
+	if err := os.WriteFile(source, []byte(tmpl), 0644); err != nil {
+		t.Fatal("failed to write template:", err)
+	}
+	if err := os.WriteFile(broken, []byte("{{.Vars.MISSING}}"), 0644); err != nil {
+		t.Fatal("failed to write template:", err)
+	}
+	if err := os.WriteFile(output, []byte("old"), 0600); err != nil {
+		t.Fatal("failed to write output:", err)
+	}
+
+	config := Config{
+		Vars: map[Mode]map[string]string{DARK: {"THEME": "gruvbox-dark"}},
+		Templates: []Template{
+			{Source: broken, Output: filepath.Join(dir, "broken.conf")},
+			{Source: source, Output: output},
+		},
+	}
+	sunset := time.Date(2024, 1, 1, 18, 30, 0, 0, time.UTC)
+	renderer := NewTemplateRenderer(&config, func() (time.Time, time.Time) {
+		return time.Time{}, sunset
+	})
+
+	// The broken template fails, but does not prevent rendering the other.
+	if err := renderer.ChangeMode(DARK); err == nil {
+		t.Error("want an error for a template with a missing variable")
+	}
+
+	data, err := os.ReadFile(output)
+	if err != nil {
+		t.Fatal("failed to read output:", err)
+	}
+	if want := "mode=dark\ntheme=gruvbox-dark\nsunset=18:30\n"; string(data) != want {
+		t.Errorf("want %q, got %q", want, data)
+	}
+	info, err := os.Stat(output)
+	if err != nil {
+		t.Fatal("failed to stat output:", err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("want permissions of the previous file to be preserved, got %v", info.Mode())
+	}
+	if _, err := os.Stat(filepath.Join(dir, "broken.conf")); !os.IsNotExist(err) {
+		t.Errorf("broken template should not have produced any output")
+	}
+
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 3 {
+		t.Errorf("want no leftover temporary files, got %v", entries)
+	}
+}
+
+func TestRenderTemplateSymlink(t *testing.T) {
+	dir := t.TempDir()
+	source := filepath.Join(dir, "colors.tmpl")
+	if err := os.WriteFile(source, []byte("mode={{.Mode}}\n"), 0644); err != nil {
+		t.Fatal("failed to write template:", err)
+	}
+	// Dotfile managers often symlink configuration files into place.
+	output := filepath.Join(dir, "colors.conf")
+	if err := os.Symlink("managed.conf", output); err != nil {
+		t.Fatal("failed to create symlink:", err)
+	}
+
+	renderer := NewTemplateRenderer(&Config{Templates: []Template{{Source: source, Output: output}}}, nil)
+	if err := renderer.ChangeMode(LIGHT); err != nil {
+		t.Fatal("failed to render template:", err)
+	}
+
+	if target, err := os.Readlink(output); err != nil || target != "managed.conf" {
+		t.Errorf("want symlink kept, got %q (%v)", target, err)
+	}
+	data, err := os.ReadFile(filepath.Join(dir, "managed.conf"))
+	if err != nil {
+		t.Fatal("failed to read output:", err)
+	}
+	if want := "mode=light\n"; string(data) != want {
+		t.Errorf("want %q, got %q", want, data)
+	}
+}
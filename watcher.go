@@ -0,0 +1,106 @@
+package darkman
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"unsafe"
+)
+
+// Events which indicate that an entry in a directory was added, removed, or
+// had its permissions changed.
+const inotifyDirMask = syscall.IN_CREATE | syscall.IN_DELETE | syscall.IN_MOVED_TO |
+	syscall.IN_MOVED_FROM | syscall.IN_ATTRIB | syscall.IN_CLOSE_WRITE
+
+// Watches directories for changes using inotify.
+type dirWatcher struct {
+	file    *os.File
+	fd      int
+	watches map[int32]string
+	mu      sync.Mutex
+	// Receives the full path of each entry that changed.
+	Events chan string
+}
+
+// Create a new watcher. It is closed when `ctx` is cancelled.
+func newDirWatcher(ctx context.Context) (*dirWatcher, error) {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK)
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialise inotify: %v", err)
+	}
+
+	// Using a non-blocking file lets the runtime poller wake up reads when
+	// the file is closed.
+	watcher := &dirWatcher{
+		file:    os.NewFile(uintptr(fd), "inotify"),
+		fd:      fd,
+		watches: make(map[int32]string),
+		Events:  make(chan string),
+	}
+
+	go func() {
+		<-ctx.Done()
+		watcher.file.Close()
+	}()
+	go watcher.read(ctx)
+
+	return watcher, nil
+}
+
+// Watch a directory for changes. Watching a directory twice is harmless.
+func (watcher *dirWatcher) Add(dir string) error {
+	wd, err := syscall.InotifyAddWatch(watcher.fd, dir, inotifyDirMask)
+	if err != nil {
+		return fmt.Errorf("failed to watch %v: %w", dir, err)
+	}
+
+	watcher.mu.Lock()
+	watcher.watches[int32(wd)] = dir
+	watcher.mu.Unlock()
+	return nil
+}
+
+func (watcher *dirWatcher) read(ctx context.Context) {
+	buf := make([]byte, 64*(syscall.SizeofInotifyEvent+syscall.NAME_MAX+1))
+	for {
+		n, err := watcher.file.Read(buf)
+		if err != nil {
+			if ctx.Err() == nil {
+				log.Println("Error reading inotify events:", err)
+			}
+			return
+		}
+
+		for offset := 0; offset+syscall.SizeofInotifyEvent <= n; {
+			event := (*syscall.InotifyEvent)(unsafe.Pointer(&buf[offset]))
+			nameBytes := buf[offset+syscall.SizeofInotifyEvent : offset+syscall.SizeofInotifyEvent+int(event.Len)]
+			offset += syscall.SizeofInotifyEvent + int(event.Len)
+
+			if event.Mask&syscall.IN_IGNORED != 0 {
+				watcher.mu.Lock()
+				delete(watcher.watches, event.Wd)
+				watcher.mu.Unlock()
+				continue
+			}
+
+			watcher.mu.Lock()
+			dir, ok := watcher.watches[event.Wd]
+			watcher.mu.Unlock()
+			if !ok {
+				continue
+			}
+
+			name := string(bytes.TrimRight(nameBytes, "\x00"))
+			select {
+			case watcher.Events <- filepath.Join(dir, name):
+			case <-ctx.Done():
+				return
+			}
+		}
+	}
+}